@@ -1,11 +1,590 @@
 package internals
 
-type APU struct{}
+// https://wiki.nesdev.org/w/index.php?title=APU
+
+var lengthTable = [32]uint8{
+	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
+	12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+// https://wiki.nesdev.org/w/index.php?title=APU_Pulse
+var dutyTable = [4][8]uint8{
+	{0, 1, 0, 0, 0, 0, 0, 0}, // 12.5%
+	{0, 1, 1, 0, 0, 0, 0, 0}, // 25%
+	{0, 1, 1, 1, 1, 0, 0, 0}, // 50%
+	{1, 0, 0, 1, 1, 1, 1, 1}, // 25% negated
+}
+
+// https://wiki.nesdev.org/w/index.php?title=APU_Triangle
+var triangleTable = [32]uint8{
+	15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0,
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// https://wiki.nesdev.org/w/index.php?title=APU_Noise
+// NTSC periods, in CPU cycles
+var noiseTable = [16]uint16{
+	4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068,
+}
+
+// https://wiki.nesdev.org/w/index.php?title=APU_DMC
+// NTSC rates, in CPU cycles
+var dmcTable = [16]uint16{
+	428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54,
+}
+
+type APU struct {
+	Bus *Bus
+
+	Pulse1   Pulse
+	Pulse2   Pulse
+	Triangle Triangle
+	Noise    Noise
+	DMC      DMC
+
+	FrameCounter FrameCounter
+
+	Channels [CHANNEL_COUNT]ChannelControl
+
+	CycleCount uint64
+}
+
+// https://wiki.nesdev.org/w/index.php?title=APU_Frame_Counter
+type FrameCounter struct {
+	FiveStep   bool
+	IRQInhibit bool
+	Interrupt  bool
+	Cycle      uint64 // CPU cycles since the start of the sequence
+}
+
+// https://wiki.nesdev.org/w/index.php?title=APU_Envelope
+type Envelope struct {
+	Start    bool
+	Loop     bool
+	Constant bool
+	Volume   uint8 // Constant volume, or the period of the divider
+	Divider  uint8
+	Decay    uint8
+}
+
+// https://wiki.nesdev.org/w/index.php?title=APU_Length_Counter
+type LengthCounter struct {
+	Enabled bool
+	Halt    bool
+	Value   uint8
+}
+
+// https://wiki.nesdev.org/w/index.php?title=APU_Sweep
+type Sweep struct {
+	Enabled bool
+	Period  uint8
+	Negate  bool
+	Shift   uint8
+	Reload  bool
+	Divider uint8
+}
+
+type Pulse struct {
+	Channel     uint8 // 1 or 2, the negate of the sweep unit differs between them
+	Duty        uint8
+	DutyStep    uint8
+	TimerPeriod uint16
+	Timer       uint16
+	Envelope    Envelope
+	Sweep       Sweep
+	Length      LengthCounter
+}
+
+type Triangle struct {
+	Control       bool // Also halts the length counter
+	LinearReload  bool
+	LinearPeriod  uint8
+	LinearCounter uint8
+	Step          uint8
+	TimerPeriod   uint16
+	Timer         uint16
+	Length        LengthCounter
+}
+
+type Noise struct {
+	Mode          bool   // Short mode, feedback from bit 6 instead of bit 1
+	ShiftRegister uint16 // 15 bit LFSR
+	TimerPeriod   uint16
+	Timer         uint16
+	Envelope      Envelope
+	Length        LengthCounter
+}
+
+type DMC struct {
+	IRQEnabled     bool
+	Interrupt      bool
+	Loop           bool
+	TimerPeriod    uint16
+	Timer          uint16
+	Level          uint8 // 7 bit output level
+	SampleAddress  uint16
+	SampleLength   uint16
+	CurrentAddress uint16
+	BytesRemaining uint16
+	SampleBuffer   uint8
+	BufferEmpty    bool
+	ShiftRegister  uint8
+	BitsRemaining  uint8
+	Silence        bool
+}
+
+func (apu *APU) Initialize() {
+	apu.Pulse1 = Pulse{Channel: 1}
+	apu.Pulse2 = Pulse{Channel: 2}
+	apu.Triangle = Triangle{}
+	apu.Noise = Noise{ShiftRegister: 1, TimerPeriod: noiseTable[0] - 1}
+	apu.DMC = DMC{TimerPeriod: dmcTable[0] - 1, BufferEmpty: true, BitsRemaining: 8, Silence: true}
+	apu.FrameCounter = FrameCounter{}
+	apu.CycleCount = 0
+}
+
+// Called once for every CPU cycle
+func (apu *APU) Cycle() {
+	// The pulse timers are clocked every other CPU cycle
+	if apu.CycleCount%2 == 1 {
+		apu.Pulse1.clockTimer()
+		apu.Pulse2.clockTimer()
+	}
+	apu.Triangle.clockTimer()
+	apu.Noise.clockTimer()
+	apu.DMC.clockTimer()
+	apu.fetchSample()
+	apu.clockFrameCounter()
+	apu.CycleCount++
+
+	// The IRQ line stays asserted until the flags are acknowledged
+	if apu.FrameCounter.Interrupt || apu.DMC.Interrupt {
+		apu.Bus.nes.CPU.InterruptIRQ()
+	}
+}
+
+// NTSC timings, in CPU cycles
+func (apu *APU) clockFrameCounter() {
+	frame := &apu.FrameCounter
+	frame.Cycle++
+
+	switch frame.Cycle {
+	case 7457:
+		apu.quarterFrame()
+	case 14913:
+		apu.quarterFrame()
+		apu.halfFrame()
+	case 22371:
+		apu.quarterFrame()
+	case 29828:
+		if !frame.FiveStep {
+			frame.setInterrupt()
+		}
+	case 29829:
+		if !frame.FiveStep {
+			apu.quarterFrame()
+			apu.halfFrame()
+			frame.setInterrupt()
+		}
+	case 29830:
+		if !frame.FiveStep {
+			frame.setInterrupt()
+			frame.Cycle = 0
+		}
+	case 37281:
+		apu.quarterFrame()
+		apu.halfFrame()
+	case 37282:
+		frame.Cycle = 0
+	}
+}
+
+func (frame *FrameCounter) setInterrupt() {
+	if !frame.IRQInhibit {
+		frame.Interrupt = true
+	}
+}
+
+func (apu *APU) quarterFrame() {
+	apu.Pulse1.Envelope.clock()
+	apu.Pulse2.Envelope.clock()
+	apu.Triangle.clockLinearCounter()
+	apu.Noise.Envelope.clock()
+}
+
+func (apu *APU) halfFrame() {
+	apu.Pulse1.Length.clock()
+	apu.Pulse2.Length.clock()
+	apu.Triangle.Length.clock()
+	apu.Noise.Length.clock()
+	apu.Pulse1.clockSweep()
+	apu.Pulse2.clockSweep()
+}
 
 func (apu *APU) ReadRegister(address uint16) uint8 {
-	panic("Not implemented")
+	switch address {
+	case 0x4015: // IF-D NT21
+		var value uint8 = 0
+		if apu.Pulse1.Length.Value > 0 {
+			value |= 1 << 0
+		}
+		if apu.Pulse2.Length.Value > 0 {
+			value |= 1 << 1
+		}
+		if apu.Triangle.Length.Value > 0 {
+			value |= 1 << 2
+		}
+		if apu.Noise.Length.Value > 0 {
+			value |= 1 << 3
+		}
+		if apu.DMC.BytesRemaining > 0 {
+			value |= 1 << 4
+		}
+		if apu.FrameCounter.Interrupt {
+			value |= 1 << 6
+		}
+		if apu.DMC.Interrupt {
+			value |= 1 << 7
+		}
+
+		// Reading the status acknowledges the frame interrupt
+		apu.FrameCounter.Interrupt = false
+		return value
+	}
+	return 0
 }
 
 func (apu *APU) WriteRegister(address uint16, value uint8) {
-	panic("Not implemented")
+	switch {
+	case address < 0x4004:
+		apu.Pulse1.writeRegister(address-0x4000, value)
+	case address < 0x4008:
+		apu.Pulse2.writeRegister(address-0x4004, value)
+	case address < 0x400C:
+		apu.Triangle.writeRegister(address-0x4008, value)
+	case address < 0x4010:
+		apu.Noise.writeRegister(address-0x400C, value)
+	case address < 0x4014:
+		apu.DMC.writeRegister(address-0x4010, value)
+	case address == 0x4015:
+		apu.Pulse1.Length.setEnabled(value&0x01 != 0)
+		apu.Pulse2.Length.setEnabled(value&0x02 != 0)
+		apu.Triangle.Length.setEnabled(value&0x04 != 0)
+		apu.Noise.Length.setEnabled(value&0x08 != 0)
+		apu.DMC.setEnabled(value&0x10 != 0)
+		apu.DMC.Interrupt = false
+	case address == 0x4017: // MI-- ----
+		apu.FrameCounter.FiveStep = value&0x80 != 0
+		apu.FrameCounter.IRQInhibit = value&0x40 != 0
+		if apu.FrameCounter.IRQInhibit {
+			apu.FrameCounter.Interrupt = false
+		}
+		apu.FrameCounter.Cycle = 0
+		if apu.FrameCounter.FiveStep {
+			apu.quarterFrame()
+			apu.halfFrame()
+		}
+	}
+}
+
+// The DMC memory reader. When the sample buffer is empty, the CPU is halted while the DMA
+// fetches the next byte from the CPU bus
+func (apu *APU) fetchSample() {
+	dmc := &apu.DMC
+	if !dmc.BufferEmpty || dmc.BytesRemaining == 0 {
+		return
+	}
+	apu.Bus.nes.CPU.startDMCDMA(dmc)
+}
+
+// Called by the DMA with the fetched byte
+func (dmc *DMC) loadSample(value uint8) {
+	if dmc.BytesRemaining == 0 { // Disabled during the DMA
+		return
+	}
+
+	dmc.SampleBuffer = value
+	dmc.BufferEmpty = false
+
+	if dmc.CurrentAddress == 0xFFFF {
+		dmc.CurrentAddress = 0x8000
+	} else {
+		dmc.CurrentAddress++
+	}
+
+	dmc.BytesRemaining--
+	if dmc.BytesRemaining == 0 {
+		if dmc.Loop {
+			dmc.restart()
+		} else if dmc.IRQEnabled {
+			dmc.Interrupt = true
+		}
+	}
+}
+
+func (envelope *Envelope) clock() {
+	if envelope.Start {
+		envelope.Start = false
+		envelope.Decay = 15
+		envelope.Divider = envelope.Volume
+		return
+	}
+	if envelope.Divider > 0 {
+		envelope.Divider--
+		return
+	}
+	envelope.Divider = envelope.Volume
+	if envelope.Decay > 0 {
+		envelope.Decay--
+	} else if envelope.Loop {
+		envelope.Decay = 15
+	}
+}
+
+func (envelope *Envelope) output() uint8 {
+	if envelope.Constant {
+		return envelope.Volume
+	}
+	return envelope.Decay
+}
+
+func (length *LengthCounter) load(index uint8) {
+	if length.Enabled {
+		length.Value = lengthTable[index&0x1F]
+	}
+}
+
+func (length *LengthCounter) setEnabled(enabled bool) {
+	length.Enabled = enabled
+	if !enabled {
+		length.Value = 0
+	}
+}
+
+func (length *LengthCounter) clock() {
+	if length.Value > 0 && !length.Halt {
+		length.Value--
+	}
+}
+
+func (pulse *Pulse) writeRegister(register uint16, value uint8) {
+	switch register {
+	case 0: // DDLC VVVV
+		pulse.Duty = value >> 6
+		pulse.Length.Halt = value&0x20 != 0
+		pulse.Envelope.Loop = value&0x20 != 0
+		pulse.Envelope.Constant = value&0x10 != 0
+		pulse.Envelope.Volume = value & 0x0F
+	case 1: // EPPP NSSS
+		pulse.Sweep.Enabled = value&0x80 != 0
+		pulse.Sweep.Period = (value >> 4) & 0x07
+		pulse.Sweep.Negate = value&0x08 != 0
+		pulse.Sweep.Shift = value & 0x07
+		pulse.Sweep.Reload = true
+	case 2: // LLLL LLLL
+		pulse.TimerPeriod = (pulse.TimerPeriod & 0x0700) | uint16(value)
+	case 3: // LLLL LHHH
+		pulse.TimerPeriod = (pulse.TimerPeriod & 0x00FF) | (uint16(value&0x07) << 8)
+		pulse.Length.load(value >> 3)
+		pulse.Envelope.Start = true
+		pulse.DutyStep = 0
+	}
+}
+
+func (pulse *Pulse) clockTimer() {
+	if pulse.Timer == 0 {
+		pulse.Timer = pulse.TimerPeriod
+		pulse.DutyStep = (pulse.DutyStep - 1) & 0x07
+	} else {
+		pulse.Timer--
+	}
+}
+
+func (pulse *Pulse) sweepTarget() uint16 {
+	change := pulse.TimerPeriod >> pulse.Sweep.Shift
+	if !pulse.Sweep.Negate {
+		return pulse.TimerPeriod + change
+	}
+	// Pulse 1 uses ones' complement, pulse 2 uses two's complement
+	if pulse.Channel == 1 {
+		change++
+	}
+	if change > pulse.TimerPeriod {
+		return 0
+	}
+	return pulse.TimerPeriod - change
+}
+
+func (pulse *Pulse) sweepMuting() bool {
+	return pulse.TimerPeriod < 8 || pulse.sweepTarget() > 0x7FF
+}
+
+func (pulse *Pulse) clockSweep() {
+	if pulse.Sweep.Divider == 0 && pulse.Sweep.Enabled && pulse.Sweep.Shift > 0 && !pulse.sweepMuting() {
+		pulse.TimerPeriod = pulse.sweepTarget()
+	}
+	if pulse.Sweep.Divider == 0 || pulse.Sweep.Reload {
+		pulse.Sweep.Divider = pulse.Sweep.Period
+		pulse.Sweep.Reload = false
+	} else {
+		pulse.Sweep.Divider--
+	}
+}
+
+// Returns the current volume of the channel (0-15)
+func (pulse *Pulse) Output() uint8 {
+	if pulse.Length.Value == 0 || pulse.sweepMuting() || dutyTable[pulse.Duty][pulse.DutyStep] == 0 {
+		return 0
+	}
+	return pulse.Envelope.output()
+}
+
+func (triangle *Triangle) writeRegister(register uint16, value uint8) {
+	switch register {
+	case 0: // CRRR RRRR
+		triangle.Control = value&0x80 != 0
+		triangle.Length.Halt = value&0x80 != 0
+		triangle.LinearPeriod = value & 0x7F
+	case 2: // LLLL LLLL
+		triangle.TimerPeriod = (triangle.TimerPeriod & 0x0700) | uint16(value)
+	case 3: // LLLL LHHH
+		triangle.TimerPeriod = (triangle.TimerPeriod & 0x00FF) | (uint16(value&0x07) << 8)
+		triangle.Length.load(value >> 3)
+		triangle.LinearReload = true
+	}
+}
+
+// The triangle timer is clocked every CPU cycle
+func (triangle *Triangle) clockTimer() {
+	if triangle.Timer == 0 {
+		triangle.Timer = triangle.TimerPeriod
+		if triangle.Length.Value > 0 && triangle.LinearCounter > 0 {
+			triangle.Step = (triangle.Step + 1) & 0x1F
+		}
+	} else {
+		triangle.Timer--
+	}
+}
+
+func (triangle *Triangle) clockLinearCounter() {
+	if triangle.LinearReload {
+		triangle.LinearCounter = triangle.LinearPeriod
+	} else if triangle.LinearCounter > 0 {
+		triangle.LinearCounter--
+	}
+	if !triangle.Control {
+		triangle.LinearReload = false
+	}
+}
+
+// Returns the current step of the sequencer (0-15). A silenced triangle holds its last value
+func (triangle *Triangle) Output() uint8 {
+	return triangleTable[triangle.Step]
+}
+
+func (noise *Noise) writeRegister(register uint16, value uint8) {
+	switch register {
+	case 0: // --LC VVVV
+		noise.Length.Halt = value&0x20 != 0
+		noise.Envelope.Loop = value&0x20 != 0
+		noise.Envelope.Constant = value&0x10 != 0
+		noise.Envelope.Volume = value & 0x0F
+	case 2: // M--- PPPP
+		noise.Mode = value&0x80 != 0
+		noise.TimerPeriod = noiseTable[value&0x0F] - 1
+	case 3: // LLLL L---
+		noise.Length.load(value >> 3)
+		noise.Envelope.Start = true
+	}
+}
+
+func (noise *Noise) clockTimer() {
+	if noise.Timer == 0 {
+		noise.Timer = noise.TimerPeriod
+		var shift uint16 = 1
+		if noise.Mode {
+			shift = 6
+		}
+		feedback := (noise.ShiftRegister & 1) ^ ((noise.ShiftRegister >> shift) & 1)
+		noise.ShiftRegister >>= 1
+		noise.ShiftRegister |= feedback << 14
+	} else {
+		noise.Timer--
+	}
+}
+
+// Returns the current volume of the channel (0-15)
+func (noise *Noise) Output() uint8 {
+	if noise.Length.Value == 0 || noise.ShiftRegister&1 == 1 {
+		return 0
+	}
+	return noise.Envelope.output()
+}
+
+func (dmc *DMC) writeRegister(register uint16, value uint8) {
+	switch register {
+	case 0: // IL-- RRRR
+		dmc.IRQEnabled = value&0x80 != 0
+		if !dmc.IRQEnabled {
+			dmc.Interrupt = false
+		}
+		dmc.Loop = value&0x40 != 0
+		dmc.TimerPeriod = dmcTable[value&0x0F] - 1
+	case 1: // -DDD DDDD
+		dmc.Level = value & 0x7F
+	case 2: // AAAA AAAA
+		dmc.SampleAddress = 0xC000 | (uint16(value) << 6)
+	case 3: // LLLL LLLL
+		dmc.SampleLength = (uint16(value) << 4) | 1
+	}
+}
+
+func (dmc *DMC) setEnabled(enabled bool) {
+	if !enabled {
+		dmc.BytesRemaining = 0
+	} else if dmc.BytesRemaining == 0 {
+		dmc.restart()
+	}
+}
+
+func (dmc *DMC) restart() {
+	dmc.CurrentAddress = dmc.SampleAddress
+	dmc.BytesRemaining = dmc.SampleLength
+}
+
+// The DMC timer is clocked every CPU cycle
+func (dmc *DMC) clockTimer() {
+	if dmc.Timer > 0 {
+		dmc.Timer--
+		return
+	}
+	dmc.Timer = dmc.TimerPeriod
+
+	if !dmc.Silence {
+		if dmc.ShiftRegister&1 == 1 {
+			if dmc.Level <= 125 {
+				dmc.Level += 2
+			}
+		} else if dmc.Level >= 2 {
+			dmc.Level -= 2
+		}
+	}
+	dmc.ShiftRegister >>= 1
+
+	dmc.BitsRemaining--
+	if dmc.BitsRemaining == 0 {
+		dmc.BitsRemaining = 8
+		if dmc.BufferEmpty {
+			dmc.Silence = true
+		} else {
+			dmc.Silence = false
+			dmc.ShiftRegister = dmc.SampleBuffer
+			dmc.BufferEmpty = true
+		}
+	}
+}
+
+// Returns the current output level of the channel (0-127)
+func (dmc *DMC) Output() uint8 {
+	return dmc.Level
 }
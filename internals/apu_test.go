@@ -0,0 +1,281 @@
+package internals
+
+import "testing"
+
+type apuWrite struct {
+	address uint16
+	value   uint8
+}
+
+// The APU of an NROM cartridge, with the registers written in order
+func newTestAPU(writes []apuWrite) *NES {
+	nes := newTestNES(0, 0x8000, 0x2000)
+	nes.APU.Initialize()
+	for _, write := range writes {
+		nes.APU.WriteRegister(write.address, write.value)
+	}
+	return nes
+}
+
+// Runs the APU on its own, the DMC DMA reads the sample without stalling a CPU
+func cycleAPU(nes *NES, cycles int) {
+	for i := 0; i < cycles; i++ {
+		nes.APU.Cycle()
+		if dmc := nes.CPU.dmc; dmc != nil {
+			dmc.loadSample(nes.Bus.Read(dmc.CurrentAddress))
+			nes.CPU.dmc = nil
+		}
+	}
+}
+
+type apuTest struct {
+	name     string
+	writes   []apuWrite
+	cycles   int
+	value    func(apu *APU) int
+	expected int
+}
+
+func runAPUTests(t *testing.T, tests []apuTest) {
+	for _, test := range tests {
+		nes := newTestAPU(test.writes)
+		cycleAPU(nes, test.cycles)
+		if value := test.value(nes.APU); value != test.expected {
+			t.Errorf("%s: got %d (0x%X), expected %d (0x%X)", test.name, value, value, test.expected, test.expected)
+		}
+	}
+}
+
+func TestAPUTimers(t *testing.T) {
+	pulseStep := func(apu *APU) int { return int(apu.Pulse1.DutyStep) }
+	triangleStep := func(apu *APU) int { return int(apu.Triangle.Step) }
+	noiseShift := func(apu *APU) int { return int(apu.Noise.ShiftRegister) }
+
+	pulse := []apuWrite{{0x4015, 0x01}, {0x4002, 0x10}, {0x4003, 0x08}}
+	triangle := []apuWrite{{0x4015, 0x04}, {0x4008, 0x7F}, {0x400A, 0x10}, {0x400B, 0x08}}
+	noise := []apuWrite{{0x4015, 0x08}, {0x400E, 0x00}}
+	shortNoise := []apuWrite{{0x4015, 0x08}, {0x400E, 0x80}}
+
+	runAPUTests(t, []apuTest{
+		// The pulse timer is clocked every other CPU cycle, the sequencer moves every (16+1)*2 cycles
+		{"Pulse first step", pulse, 2, pulseStep, 7},
+		{"Pulse before the second step", pulse, 35, pulseStep, 7},
+		{"Pulse second step", pulse, 36, pulseStep, 6},
+		{"Pulse wraps around", pulse, 2 + 34*7, pulseStep, 0},
+
+		// The triangle only moves once the linear counter is loaded by the first quarter frame
+		{"Triangle without linear counter", triangle, 7456, triangleStep, 0},
+		{"Triangle first step", triangle, 7464, triangleStep, 1},
+		{"Triangle timer", triangle, 7464 + 17*3, triangleStep, 4},
+
+		// The shift register starts at 1, the timer period is 4 cycles
+		{"Noise first shift", noise, 1, noiseShift, 0x4000},
+		{"Noise second shift", noise, 5, noiseShift, 0x2000},
+		{"Noise feedback from bit 1", noise, 1 + 4*14, noiseShift, 0x4001},
+		{"Noise feedback from bit 6", shortNoise, 1 + 4*9, noiseShift, 0x4020},
+	})
+}
+
+func TestAPUCounters(t *testing.T) {
+	pulseLength := func(apu *APU) int { return int(apu.Pulse1.Length.Value) }
+	decay := func(apu *APU) int { return int(apu.Pulse1.Envelope.Decay) }
+	linearCounter := func(apu *APU) int { return int(apu.Triangle.LinearCounter) }
+	status := func(apu *APU) int { return int(apu.ReadRegister(0x4015)) }
+
+	pulse := []apuWrite{{0x4015, 0x01}, {0x4003, 0x08}}
+	triangle := func(control uint8) []apuWrite {
+		return []apuWrite{{0x4015, 0x04}, {0x4008, control | 0x05}, {0x400B, 0x08}}
+	}
+
+	runAPUTests(t, []apuTest{
+		{"Length loaded", pulse, 0, pulseLength, 254},
+		{"Length disabled", []apuWrite{{0x4003, 0x08}}, 0, pulseLength, 0},
+		{"Length cleared", append(pulse, apuWrite{0x4015, 0x00}), 0, pulseLength, 0},
+		{"Length after a half frame", pulse, 14913, pulseLength, 253},
+		{"Length after a frame", pulse, 29829, pulseLength, 252},
+		{"Length halted", append(pulse, apuWrite{0x4000, 0x20}), 29829, pulseLength, 254},
+		{"Length status", append(pulse, apuWrite{0x4015, 0x0F}, apuWrite{0x400F, 0x08}), 0, status, 0x09},
+
+		// The envelope is started by the first quarter frame, then decays once per quarter frame
+		{"Envelope started", pulse, 7457, decay, 15},
+		{"Envelope decay", pulse, 22371, decay, 13},
+		{"Envelope divider", append(pulse, apuWrite{0x4000, 0x01}), 22371, decay, 14},
+		{"Envelope end", pulse, 29830*4 + 29829, decay, 0},
+		{"Envelope loop", append(pulse, apuWrite{0x4000, 0x20}), 29830*4 + 7457, decay, 15},
+
+		{"Linear counter before a quarter frame", triangle(0), 7456, linearCounter, 0},
+		{"Linear counter reloaded", triangle(0), 7457, linearCounter, 5},
+		{"Linear counter clocked", triangle(0), 22371, linearCounter, 3},
+		{"Linear counter with control", triangle(0x80), 22371, linearCounter, 5},
+	})
+}
+
+func TestAPUSweep(t *testing.T) {
+	muted := func(pulse *Pulse) int {
+		if pulse.sweepMuting() {
+			return 1
+		}
+		return 0
+	}
+	pulse1Muted := func(apu *APU) int { return muted(&apu.Pulse1) }
+	pulse2Muted := func(apu *APU) int { return muted(&apu.Pulse2) }
+	pulse1Period := func(apu *APU) int { return int(apu.Pulse1.TimerPeriod) }
+	pulse2Period := func(apu *APU) int { return int(apu.Pulse2.TimerPeriod) }
+
+	period := func(base uint16, sweep uint8, value uint16) []apuWrite {
+		return []apuWrite{{base + 1, sweep}, {base + 2, uint8(value)}, {base + 3, uint8(value >> 8)}}
+	}
+
+	runAPUTests(t, []apuTest{
+		{"Period under 8", period(0x4000, 0x00, 0x007), 0, pulse1Muted, 1},
+		{"Period of 8", period(0x4000, 0x01, 0x008), 0, pulse1Muted, 0},
+		// The target is checked even when the sweep is disabled
+		{"Target overflow", period(0x4000, 0x00, 0x7FF), 0, pulse1Muted, 1},
+		{"Target in range", period(0x4000, 0x01, 0x500), 0, pulse1Muted, 0},
+		{"Target overflow on pulse 2", period(0x4004, 0x01, 0x600), 0, pulse2Muted, 1},
+		{"Negated target", period(0x4000, 0x08, 0x7FF), 0, pulse1Muted, 0},
+
+		{"Sweep up", period(0x4000, 0x81, 0x100), 14913, pulse1Period, 0x180},
+		// Pulse 1 subtracts one more
+		{"Sweep down on pulse 1", period(0x4000, 0x89, 0x100), 14913, pulse1Period, 0x7F},
+		{"Sweep down on pulse 2", period(0x4004, 0x89, 0x100), 14913, pulse2Period, 0x80},
+		{"Muted sweep", period(0x4000, 0x81, 0x600), 29829, pulse1Period, 0x600},
+		{"Sweep disabled", period(0x4000, 0x01, 0x100), 29829, pulse1Period, 0x100},
+	})
+}
+
+func TestAPUFrameCounter(t *testing.T) {
+	// The envelope is started by the first quarter frame, then decays once per quarter frame
+	quarterFrames := func(apu *APU) int {
+		if apu.Pulse1.Envelope.Start {
+			return 0
+		}
+		return 16 - int(apu.Pulse1.Envelope.Decay)
+	}
+	halfFrames := func(apu *APU) int { return 254 - int(apu.Pulse1.Length.Value) }
+
+	fourStep := []apuWrite{{0x4015, 0x01}, {0x4000, 0x00}, {0x4003, 0x08}}
+	fiveStep := append(fourStep, apuWrite{0x4017, 0x80})
+
+	runAPUTests(t, []apuTest{
+		{"Before the first step", fourStep, 7456, quarterFrames, 0},
+		{"First step", fourStep, 7457, quarterFrames, 1},
+		{"Before the second step", fourStep, 14912, halfFrames, 0},
+		{"Second step", fourStep, 14913, quarterFrames, 2},
+		{"Second step half frame", fourStep, 14913, halfFrames, 1},
+		{"Third step", fourStep, 22371, quarterFrames, 3},
+		{"Third step half frame", fourStep, 22371, halfFrames, 1},
+		{"Before the fourth step", fourStep, 29828, quarterFrames, 3},
+		{"Fourth step", fourStep, 29829, quarterFrames, 4},
+		{"Fourth step half frame", fourStep, 29829, halfFrames, 2},
+		{"Next sequence", fourStep, 29830 + 7457, quarterFrames, 5},
+
+		// Writing $4017 with the five step mode clocks the units at once
+		{"Five step write", fiveStep, 0, quarterFrames, 1},
+		{"Five step write half frame", fiveStep, 0, halfFrames, 1},
+		{"Five step second step", fiveStep, 14913, halfFrames, 2},
+		{"Five step skips 29829", fiveStep, 29829, quarterFrames, 4},
+		{"Five step fifth step", fiveStep, 37281, quarterFrames, 5},
+		{"Five step fifth step half frame", fiveStep, 37281, halfFrames, 3},
+		{"Five step next sequence", fiveStep, 37282 + 7457, quarterFrames, 6},
+	})
+}
+
+func TestAPUFrameIRQ(t *testing.T) {
+	interrupt := func(apu *APU) int {
+		if apu.FrameCounter.Interrupt {
+			return 1
+		}
+		return 0
+	}
+
+	runAPUTests(t, []apuTest{
+		{"Before the last step", nil, 29827, interrupt, 0},
+		{"Last step", nil, 29828, interrupt, 1},
+		{"End of the sequence", nil, 29830, interrupt, 1},
+		{"Inhibited", []apuWrite{{0x4017, 0x40}}, 29830, interrupt, 0},
+		{"Five step", []apuWrite{{0x4017, 0x80}}, 37282, interrupt, 0},
+	})
+
+	nes := newTestAPU(nil)
+	cycleAPU(nes, 29830)
+	if !nes.CPU.irqLine {
+		t.Error("Frame interrupt not asserted on the CPU")
+	}
+	if status := nes.APU.ReadRegister(0x4015); status&0x40 == 0 {
+		t.Errorf("Frame interrupt not in the status: %02X", status)
+	}
+	if status := nes.APU.ReadRegister(0x4015); status&0x40 != 0 {
+		t.Errorf("Frame interrupt not acknowledged by reading the status: %02X", status)
+	}
+	nes.CPU.irqLine = false
+	cycleAPU(nes, 1)
+	if nes.CPU.irqLine {
+		t.Error("Acknowledged frame interrupt still asserted")
+	}
+
+	nes = newTestAPU(nil)
+	cycleAPU(nes, 29830)
+	nes.APU.WriteRegister(0x4017, 0x40)
+	if nes.APU.FrameCounter.Interrupt {
+		t.Error("Frame interrupt not cleared by setting the inhibit flag")
+	}
+}
+
+func TestAPUDMC(t *testing.T) {
+	// One byte sample at $C000, at the fastest rate
+	sample := func(flags uint8) []apuWrite {
+		return []apuWrite{{0x4010, flags | 0x0F}, {0x4012, 0x00}, {0x4013, 0x00}, {0x4015, 0x10}}
+	}
+	interrupt := func(apu *APU) int {
+		if apu.DMC.Interrupt {
+			return 1
+		}
+		return 0
+	}
+	bytesRemaining := func(apu *APU) int { return int(apu.DMC.BytesRemaining) }
+	status := func(apu *APU) int { return int(apu.ReadRegister(0x4015) & 0x90) }
+
+	runAPUTests(t, []apuTest{
+		{"Sample started", sample(0x00), 0, bytesRemaining, 1},
+		{"Sample started status", sample(0x00), 0, status, 0x10},
+		{"Sample end", sample(0x00), 1, bytesRemaining, 0},
+		{"No IRQ", sample(0x00), 1, interrupt, 0},
+		{"IRQ", sample(0x80), 1, interrupt, 1},
+		{"IRQ status", sample(0x80), 1, status, 0x80},
+		// A loop restarts the sample instead of raising the IRQ
+		{"Loop", sample(0xC0), 54 * 8 * 4, bytesRemaining, 1},
+		{"Loop without IRQ", sample(0xC0), 54 * 8 * 4, interrupt, 0},
+		{"Loop status", sample(0xC0), 54 * 8 * 4, status, 0x10},
+	})
+
+	// The sample is read by the DMA after the cycle, the IRQ is asserted on the next one
+	nes := newTestAPU(sample(0x80))
+	cycleAPU(nes, 2)
+	if !nes.CPU.irqLine {
+		t.Error("DMC interrupt not asserted on the CPU")
+	}
+	nes.APU.ReadRegister(0x4015)
+	if !nes.APU.DMC.Interrupt {
+		t.Error("DMC interrupt acknowledged by reading the status")
+	}
+	nes.APU.WriteRegister(0x4015, 0x00)
+	if nes.APU.DMC.Interrupt {
+		t.Error("DMC interrupt not acknowledged by writing the status")
+	}
+
+	nes = newTestAPU(sample(0x80))
+	cycleAPU(nes, 1)
+	nes.APU.WriteRegister(0x4010, 0x0F)
+	if nes.APU.DMC.Interrupt {
+		t.Error("DMC interrupt not cleared by disabling the IRQ")
+	}
+
+	nes = newTestAPU(sample(0x40))
+	nes.Cartridge.PRG_ROM[0x4000] = 0x42
+	cycleAPU(nes, 54*8*2)
+	if nes.APU.DMC.CurrentAddress != 0xC000 || nes.APU.DMC.SampleBuffer != 0x42 {
+		t.Errorf("Looping sample not read again from $C000: $%04X %02X",
+			nes.APU.DMC.CurrentAddress, nes.APU.DMC.SampleBuffer)
+	}
+}
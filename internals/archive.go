@@ -0,0 +1,95 @@
+package internals
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/hiumee/NES/internals/patch"
+)
+
+var (
+	ErrNoArchiveEntry  = errors.New("the archive has no supported ROM entry")
+	ErrArchiveTooLarge = errors.New("the decompressed file is larger than any ROM")
+)
+
+// Extensions of the entries that are loaded from the .zip archives
+var ARCHIVE_EXTENSIONS = []string{".nes", ".fds", ".nsf", ".nsfe", ".unf", ".unif"}
+
+// Decompresses .zip and .gz files, detected by their magic bytes. Other files are returned as they
+// are. In a .zip archive, the entry with the given name is used, or the first supported one
+func decompress(data []byte, entry string) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")) || bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		return unzip(data, entry)
+	case bytes.HasPrefix(data, []byte{0x1F, 0x8B}):
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return readDecompressed(reader)
+	default:
+		return data, nil
+	}
+}
+
+func unzip(data []byte, entry string) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if entry != "" {
+			if file.Name != entry && path.Base(file.Name) != entry {
+				continue
+			}
+		} else if !supportedEntry(file.Name) {
+			continue
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return readDecompressed(reader)
+	}
+
+	if entry != "" {
+		return nil, fmt.Errorf("%w: %s not found", ErrNoArchiveEntry, entry)
+	}
+	return nil, ErrNoArchiveEntry
+}
+
+// Stops after patch.MaxTargetSize bytes, so that a decompression bomb can't use all the memory
+func readDecompressed(reader io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(reader, patch.MaxTargetSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > patch.MaxTargetSize {
+		return nil, ErrArchiveTooLarge
+	}
+	return data, nil
+}
+
+func supportedEntry(name string) bool {
+	extension := strings.ToLower(path.Ext(name))
+	for _, supported := range ARCHIVE_EXTENSIONS {
+		if extension == supported {
+			return true
+		}
+	}
+	return false
+}
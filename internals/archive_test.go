@@ -0,0 +1,106 @@
+package internals
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hiumee/NES/internals/patch"
+)
+
+func writeArchive(t *testing.T, name string, data []byte) string {
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadArchives(t *testing.T) {
+	rom, err := os.ReadFile("tests/nestest.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{{"readme.txt", []byte("Not a ROM")}, {"roms/nestest.nes", rom}, {"other.bin", []byte("Not a ROM")}} {
+		writer, err := archive.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write(entry.data)
+	}
+	archive.Close()
+	// The extension is ignored, the archives are detected by their content
+	zipFile := writeArchive(t, "roms.bin", buffer.Bytes())
+
+	nes := NewNES()
+	if err := nes.LoadFile(zipFile); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(nes.Cartridge.PRG_ROM, rom[16:16+16*1024]) {
+		t.Error("Wrong ROM loaded from the .zip")
+	}
+
+	nes = NewNES()
+	nes.ArchiveEntry = "other.bin"
+	if err := nes.LoadFile(zipFile); !errors.Is(err, ErrBadMagic) {
+		t.Error("Selected entry not loaded: ", err)
+	}
+	nes = NewNES()
+	nes.ArchiveEntry = "missing.nes"
+	if err := nes.LoadFile(zipFile); !errors.Is(err, ErrNoArchiveEntry) {
+		t.Error("Missing entry not reported: ", err)
+	}
+
+	buffer.Reset()
+	archive = zip.NewWriter(&buffer)
+	writer, _ := archive.Create("readme.txt")
+	writer.Write([]byte("Not a ROM"))
+	archive.Close()
+	if err := NewNES().LoadFile(writeArchive(t, "empty.zip", buffer.Bytes())); !errors.Is(err, ErrNoArchiveEntry) {
+		t.Error("Archive without ROM not reported: ", err)
+	}
+
+	buffer.Reset()
+	compressor := gzip.NewWriter(&buffer)
+	compressor.Write(rom)
+	compressor.Close()
+	nes = NewNES()
+	if err := nes.LoadFile(writeArchive(t, "nestest.nes.gz", buffer.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(nes.Cartridge.PRG_ROM, rom[16:16+16*1024]) {
+		t.Error("Wrong ROM loaded from the .gz")
+	}
+}
+
+func TestArchiveTooLarge(t *testing.T) {
+	// Zeros compress well, the archives are small
+	zeros := make([]byte, patch.MaxTargetSize+1)
+
+	var buffer bytes.Buffer
+	compressor := gzip.NewWriter(&buffer)
+	compressor.Write(zeros)
+	compressor.Close()
+	if err := NewNES().LoadFile(writeArchive(t, "bomb.nes.gz", buffer.Bytes())); !errors.Is(err, ErrArchiveTooLarge) {
+		t.Error("Large .gz not rejected: ", err)
+	}
+
+	buffer.Reset()
+	archive := zip.NewWriter(&buffer)
+	writer, _ := archive.Create("bomb.nes")
+	writer.Write(zeros)
+	archive.Close()
+	if err := NewNES().LoadFile(writeArchive(t, "bomb.zip", buffer.Bytes())); !errors.Is(err, ErrArchiveTooLarge) {
+		t.Error("Large .zip not rejected: ", err)
+	}
+}
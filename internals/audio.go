@@ -0,0 +1,246 @@
+package internals
+
+import (
+	"math"
+)
+
+const CPU_FREQUENCY = 1789773
+
+// Implemented by anything that can play or store the audio output of the emulator
+type AudioSink interface {
+	SampleRate() int
+	// Mono samples in the range [-1, 1]
+	WriteSamples(samples []float32) error
+}
+
+// Sinks that buffer the samples report how full they are (0-1), so the resampling rate can be adjusted
+type BufferedAudioSink interface {
+	AudioSink
+	Fill() float64
+}
+
+const (
+	CHANNEL_PULSE1 = iota
+	CHANNEL_PULSE2
+	CHANNEL_TRIANGLE
+	CHANNEL_NOISE
+	CHANNEL_DMC
+	CHANNEL_COUNT
+)
+
+var CHANNEL_NAMES = [CHANNEL_COUNT]string{"pulse1", "pulse2", "triangle", "noise", "dmc"}
+
+// Mixer settings of a channel
+type ChannelControl struct {
+	Mute   bool
+	Solo   bool    // When any channel is soloed, only the soloed channels are heard
+	Volume float32 // Gain applied to the channel, 1 is the original level
+}
+
+// Levels of the channels before mixing, in the ranges returned by their Output functions
+func (apu *APU) channelLevels() [CHANNEL_COUNT]float32 {
+	return [CHANNEL_COUNT]float32{
+		float32(apu.Pulse1.Output()),
+		float32(apu.Pulse2.Output()),
+		float32(apu.Triangle.Output()),
+		float32(apu.Noise.Output()),
+		float32(apu.DMC.Output()),
+	}
+}
+
+// https://wiki.nesdev.org/w/index.php?title=APU_Mixer
+func mix(levels [CHANNEL_COUNT]float32) float32 {
+	var output float32
+	if pulse := levels[CHANNEL_PULSE1] + levels[CHANNEL_PULSE2]; pulse > 0 {
+		output += 95.88 / (8128/pulse + 100)
+	}
+	if tnd := levels[CHANNEL_TRIANGLE]/8227 + levels[CHANNEL_NOISE]/12241 + levels[CHANNEL_DMC]/22638; tnd > 0 {
+		output += 159.79 / (1/tnd + 100)
+	}
+	return output
+}
+
+// Mixes the channels of the APU using the mixer settings, the result is in the range [0, 1]
+func (apu *APU) Output() float32 {
+	levels := apu.channelLevels()
+
+	solo := false
+	for _, control := range apu.Channels {
+		solo = solo || control.Solo
+	}
+	for channel, control := range apu.Channels {
+		if control.Mute || (solo && !control.Solo) {
+			levels[channel] = 0
+		} else {
+			levels[channel] *= control.Volume
+		}
+	}
+
+	return mix(levels)
+}
+
+// The output of a single channel as if the others were silent. Ignores the mixer settings
+func (apu *APU) ChannelOutput(channel int) float32 {
+	var levels [CHANNEL_COUNT]float32
+	levels[channel] = apu.channelLevels()[channel]
+	return mix(levels)
+}
+
+const (
+	resamplerWidth  = 16 // Taps of the band-limited step, in output samples
+	resamplerPhases = 64
+	resamplerCutoff = 0.9 // Relative to the output Nyquist frequency
+	highpassCutoff  = 90  // Hz, the first high-pass filter of the NES
+
+	// Maximum adjustment of the output rate done by the dynamic rate control
+	// https://docs.libretro.com/development/cores/dynamic-rate-control/
+	maxRateDelta = 0.005
+)
+
+// Band-limited resampler. Each change of the input amplitude is added to the output as a
+// band-limited step, so the cost only depends on how often the signal changes
+// http://www.slack.net/~ant/bl-synth/
+type Resampler struct {
+	ratio  float64 // Output samples per input sample
+	time   float64 // Position of the next input sample in the output buffer
+	last   float32
+	buffer []float32
+	kernel [resamplerPhases][resamplerWidth]float32
+
+	integrator float32
+	highpass   float32
+	previous   float32
+	output     float32
+}
+
+func NewResampler(inputRate float64, outputRate float64) *Resampler {
+	resampler := &Resampler{
+		ratio:    outputRate / inputRate,
+		buffer:   make([]float32, 4096),
+		highpass: float32(math.Exp(-2 * math.Pi * highpassCutoff / outputRate)),
+	}
+
+	// Windowed sinc impulses, one for every fractional position of the step
+	for phase := 0; phase < resamplerPhases; phase++ {
+		var sum float64
+		var taps [resamplerWidth]float64
+		for k := 0; k < resamplerWidth; k++ {
+			x := float64(k-resamplerWidth/2) + 1 - float64(phase)/resamplerPhases
+			taps[k] = sinc(resamplerCutoff*x) * blackman(x/(resamplerWidth/2))
+			sum += taps[k]
+		}
+		for k := 0; k < resamplerWidth; k++ {
+			resampler.kernel[phase][k] = float32(taps[k] / sum)
+		}
+	}
+
+	return resampler
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+func blackman(x float64) float64 {
+	if x < -1 || x > 1 {
+		return 0
+	}
+	return 0.42 + 0.5*math.Cos(math.Pi*x) + 0.08*math.Cos(2*math.Pi*x)
+}
+
+func (resampler *Resampler) SetRate(inputRate float64, outputRate float64) {
+	resampler.ratio = outputRate / inputRate
+}
+
+// Called once for every input sample
+func (resampler *Resampler) AddSample(amplitude float32) {
+	if amplitude != resampler.last {
+		delta := amplitude - resampler.last
+		resampler.last = amplitude
+
+		index := int(resampler.time)
+		for index+resamplerWidth >= len(resampler.buffer) {
+			resampler.buffer = append(resampler.buffer, make([]float32, len(resampler.buffer))...)
+		}
+		phase := int((resampler.time - float64(index)) * resamplerPhases)
+		for k := 0; k < resamplerWidth; k++ {
+			resampler.buffer[index+k] += delta * resampler.kernel[phase][k]
+		}
+	}
+	resampler.time += resampler.ratio
+}
+
+// Number of output samples that will not change anymore
+func (resampler *Resampler) Available() int {
+	return int(resampler.time)
+}
+
+// Moves up to len(samples) output samples into samples and returns how many were read
+func (resampler *Resampler) ReadSamples(samples []float32) int {
+	count := resampler.Available()
+	if count > len(samples) {
+		count = len(samples)
+	}
+
+	for i := 0; i < count; i++ {
+		resampler.integrator += resampler.buffer[i]
+		// Removes the DC offset, like the high-pass filter of the console
+		resampler.output = resampler.integrator - resampler.previous + resampler.highpass*resampler.output
+		resampler.previous = resampler.integrator
+		samples[i] = resampler.output
+	}
+
+	copy(resampler.buffer, resampler.buffer[count:])
+	for i := len(resampler.buffer) - count; i < len(resampler.buffer); i++ {
+		resampler.buffer[i] = 0
+	}
+	resampler.time -= float64(count)
+
+	return count
+}
+
+// Connects the APU output to a sink
+type Audio struct {
+	Sink      AudioSink
+	Resampler *Resampler
+	Err       error // First error returned by the sink; the following samples are dropped
+	samples   []float32
+
+	// Keeps a buffered sink half full by slightly changing the resampling rate. Used when
+	// something other than the audio (e.g. vsync) paces the emulation
+	RateControl bool
+}
+
+func NewAudio(sink AudioSink) *Audio {
+	return &Audio{
+		Sink:      sink,
+		Resampler: NewResampler(CPU_FREQUENCY, float64(sink.SampleRate())),
+		samples:   make([]float32, 1024),
+	}
+}
+
+func (audio *Audio) AddSample(amplitude float32) {
+	audio.Resampler.AddSample(amplitude)
+	if audio.Resampler.Available() >= len(audio.samples) {
+		audio.Flush()
+	}
+}
+
+// Writes all the finished samples to the sink
+func (audio *Audio) Flush() error {
+	for audio.Resampler.Available() > 0 {
+		count := audio.Resampler.ReadSamples(audio.samples)
+		if audio.Err == nil {
+			audio.Err = audio.Sink.WriteSamples(audio.samples[:count])
+		}
+	}
+
+	if buffered, ok := audio.Sink.(BufferedAudioSink); ok && audio.RateControl {
+		rate := float64(audio.Sink.SampleRate()) * (1 + maxRateDelta*(1-2*buffered.Fill()))
+		audio.Resampler.SetRate(CPU_FREQUENCY, rate)
+	}
+	return audio.Err
+}
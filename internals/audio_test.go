@@ -0,0 +1,125 @@
+package internals
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResamplerSquareWave(t *testing.T) {
+	resampler := NewResampler(CPU_FREQUENCY, 44100)
+
+	// One second of a 1 kHz square wave
+	var samples []float32
+	buffer := make([]float32, 512)
+	for i := 0; i < CPU_FREQUENCY; i++ {
+		var amplitude float32 = 0
+		if (i*2000/CPU_FREQUENCY)%2 == 0 {
+			amplitude = 0.5
+		}
+		resampler.AddSample(amplitude)
+		if resampler.Available() >= len(buffer) {
+			count := resampler.ReadSamples(buffer)
+			samples = append(samples, buffer[:count]...)
+		}
+	}
+	count := resampler.ReadSamples(buffer)
+	samples = append(samples, buffer[:count]...)
+
+	if len(samples) < 44100-1 || len(samples) > 44100 {
+		t.Error("Unexpected number of samples: ", len(samples))
+	}
+
+	var peak float64
+	for _, sample := range samples[44100/2:] {
+		peak = math.Max(peak, math.Abs(float64(sample)))
+	}
+	// The DC offset is removed, so the wave swings around 0 with some ringing at the edges
+	if peak < 0.2 || peak > 0.4 {
+		t.Error("Unexpected peak amplitude: ", peak)
+	}
+}
+
+func TestWAVSink(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "output.wav")
+	sink, err := NewWAVSink(filename, 48000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.WriteSamples([]float32{0, 0.5, -0.5, 2})
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 44+8 || string(data[0:4]) != "RIFF" || string(data[36:40]) != "data" {
+		t.Fatal("Invalid WAV file")
+	}
+	if binary.LittleEndian.Uint32(data[24:]) != 48000 || binary.LittleEndian.Uint32(data[40:]) != 8 {
+		t.Error("Invalid WAV header")
+	}
+	if int16(binary.LittleEndian.Uint16(data[50:])) != 32767 {
+		t.Error("Samples are not clipped")
+	}
+}
+
+func TestRingBufferBlocksWhenFull(t *testing.T) {
+	ring := NewRingBuffer(44100, 4)
+	written := make(chan error)
+	go func() {
+		written <- ring.WriteSamples([]float32{1, 2, 3, 4, 5, 6})
+	}()
+
+	samples := make([]float32, 4)
+	var read []float32
+	for len(read) < 6 {
+		count := ring.ReadSamples(samples)
+		read = append(read, samples[:count]...)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	for i, sample := range read {
+		if sample != float32(i+1) {
+			t.Fatal("Samples out of order: ", read)
+		}
+	}
+
+	ring.WriteSamples([]float32{1, 2, 3, 4})
+	go ring.Close()
+	if err := ring.WriteSamples([]float32{5}); err != ErrRingBufferClosed {
+		t.Error("Expected the write to fail after closing, got: ", err)
+	}
+}
+
+func TestMixerChannelControls(t *testing.T) {
+	apu := &APU{}
+	apu.Initialize()
+	for channel := range apu.Channels {
+		apu.Channels[channel].Volume = 1
+	}
+	apu.Triangle.Step = 0 // Level 15
+	apu.DMC.Level = 64
+
+	all := apu.Output()
+	if all != mix([CHANNEL_COUNT]float32{0, 0, 15, 0, 64}) {
+		t.Error("Unexpected mix: ", all)
+	}
+
+	apu.Channels[CHANNEL_DMC].Solo = true
+	if apu.Output() != apu.ChannelOutput(CHANNEL_DMC) {
+		t.Error("Solo does not isolate the channel")
+	}
+
+	apu.Channels[CHANNEL_DMC].Solo = false
+	apu.Channels[CHANNEL_TRIANGLE].Mute = true
+	apu.Channels[CHANNEL_DMC].Volume = 0.5
+	if apu.Output() != mix([CHANNEL_COUNT]float32{0, 0, 0, 0, 32}) {
+		t.Error("Mute or volume not applied")
+	}
+}
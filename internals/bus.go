@@ -19,11 +19,8 @@ func (memory *Bus) Read(address uint16) uint8 {
 		return memory.nes.RAM[address%0x0800]
 	case address < 0x4000:
 		return memory.nes.PPU.ReadRegister(0x2000 + address%0x8)
-	case address < 0x4014: // Shouldn't read this
-		panic("Should not be able to read this")
-		// return memory.nes.APU.ReadRegister(address)
-	case address == 0x4014:
-		return memory.nes.PPU.ReadRegister(address)
+	case address < 0x4015: // Write only, reached by the dummy reads of the CPU
+		return 0
 	case address == 0x4015:
 		return memory.nes.APU.ReadRegister(address)
 	case address == 0x4016:
@@ -32,6 +29,10 @@ func (memory *Bus) Read(address uint16) uint8 {
 		return memory.nes.Controllers[1].ReadState()
 	case address < 0x4020:
 		return 0
+	case address < 0x40A0 && memory.nes.FDS != nil:
+		return memory.nes.FDS.ReadRegister(address)
+	case memory.nes.NSF != nil:
+		return memory.nes.NSF.Read(address)
 	default:
 		return memory.nes.Cartridge.Read(address)
 	}
@@ -50,17 +51,21 @@ func (memory *Bus) Write(address uint16, value uint8) {
 	case address < 0x4000:
 		memory.nes.PPU.WriteRegister(0x2000+address%0x08, value)
 	case address < 0x4014:
-		// memory.nes.APU.WriteRegister(address, value)
+		memory.nes.APU.WriteRegister(address, value)
 	case address == 0x4014:
-		memory.nes.PPU.WriteRegister(address, value)
+		memory.nes.CPU.startOAMDMA(value)
 	case address == 0x4015:
-		// memory.nes.APU.WriteRegister(address, value)
+		memory.nes.APU.WriteRegister(address, value)
 	case address == 0x4016:
 		memory.nes.Controllers[0].WriteState(value)
 	case address == 0x4017:
-		//memory.nes.APU.WriteRegister(address, value)
+		memory.nes.APU.WriteRegister(address, value)
 	case address < 0x4020:
 		//0
+	case address < 0x40A0 && memory.nes.FDS != nil:
+		memory.nes.FDS.WriteRegister(address, value)
+	case memory.nes.NSF != nil:
+		memory.nes.NSF.Write(address, value)
 	default:
 		memory.nes.Cartridge.Write(address, value)
 	}
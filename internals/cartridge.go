@@ -1,43 +1,213 @@
 package internals
 
+import (
+	"io"
+	"math/bits"
+)
+
+// https://wiki.nesdev.org/w/index.php?title=INES
+// https://wiki.nesdev.org/w/index.php?title=NES_2.0
 type Header struct {
 	PRG_ROM_size    uint
 	CHR_ROM_size    uint
+	CHR_RAM_size    uint // Used when there is no CHR-ROM
 	PRG_RAM_size    uint
 	Mirroring       bool
 	PersistentRAM   bool // 0x6000 - 0x7FFF
 	Trainer         bool // 0x7000 - 0x71FF
-	IgnoreMorriring bool
+	IgnoreMorriring bool // Four-screen VRAM on the cartridge
 	Mapper          uint
 	VSUnisystem     bool
+	PlayChoice      bool
+
+	// NES 2.0 only, the sizes are in bytes
+	NES2            bool
+	Submapper       uint
+	PRG_NVRAM_size  uint
+	CHR_NVRAM_size  uint
+	Timing          uint8 // TIMING_*
+	ConsoleType     uint8 // CONSOLE_*
+	VSPPUType       uint8
+	VSHardwareType  uint8
+	ExtendedConsole uint8 // Used when ConsoleType is CONSOLE_EXTENDED
+	MiscROMs        uint8
+	ExpansionDevice uint8 // Default expansion device, 1 is the standard controllers
+}
+
+const (
+	TIMING_NTSC = iota
+	TIMING_PAL
+	TIMING_MULTIPLE // Works in both regions
+	TIMING_DENDY
+)
+
+const (
+	CONSOLE_NES = iota
+	CONSOLE_VS_SYSTEM
+	CONSOLE_PLAYCHOICE
+	CONSOLE_EXTENDED
+)
+
+// Decodes the 16 byte header of an iNES or NES 2.0 file
+func parseHeader(data []byte) Header {
+	var header Header
+	header.PRG_ROM_size = uint(data[4]) * 16 * 1024
+	header.CHR_ROM_size = uint(data[5]) * 8 * 1024
+
+	// Flags 6
+	/*
+		Mirroring
+		Battery RAM
+		Trainer
+		Ignore mirroring
+		Lower nibble of Mapper #
+	*/
+	flags6 := data[6]
+	header.Mirroring = flags6&(0x1<<0) != 0
+	header.PersistentRAM = flags6&(0x1<<1) != 0
+	header.Trainer = flags6&(0x1<<2) != 0
+	header.IgnoreMorriring = flags6&(0x1<<3) != 0
+	header.Mapper = uint(flags6&0xF0) >> 4
+
+	// Flags 7
+	/*
+		VS Unisystem
+		PlayChoice-10
+		NES2.0 Format [2-3]
+		Upper nibble of Mapper #
+	*/
+	flags7 := data[7]
+	header.VSUnisystem = flags7&(0x1<<0) != 0
+	header.PlayChoice = flags7&(0x1<<1) != 0
+	header.Mapper = uint(flags7&0xF0) | header.Mapper
+
+	if flags7&0x0C == 0x08 {
+		parseNES2Header(&header, data)
+	} else {
+		header.PRG_RAM_size = uint(data[8]) * 8 * 1024
+	}
+	return header
+}
+
+// Reads the NES 2.0 fields of the 16 byte header. The iNES fields must already be set
+func parseNES2Header(header *Header, data []byte) {
+	header.NES2 = true
+	header.Mapper |= uint(data[8]&0x0F) << 8
+	header.Submapper = uint(data[8] >> 4)
+
+	header.PRG_ROM_size = nes2ROMSize(data[4], data[9]&0x0F, 16*1024)
+	header.CHR_ROM_size = nes2ROMSize(data[5], data[9]>>4, 8*1024)
+
+	header.PRG_RAM_size = nes2RAMSize(data[10] & 0x0F)
+	header.PRG_NVRAM_size = nes2RAMSize(data[10] >> 4)
+	header.CHR_RAM_size = nes2RAMSize(data[11] & 0x0F)
+	header.CHR_NVRAM_size = nes2RAMSize(data[11] >> 4)
+
+	header.Timing = data[12] & 0x03
+
+	header.ConsoleType = data[7] & 0x03
+	switch header.ConsoleType {
+	case CONSOLE_VS_SYSTEM:
+		header.VSPPUType = data[13] & 0x0F
+		header.VSHardwareType = data[13] >> 4
+	case CONSOLE_EXTENDED:
+		header.ExtendedConsole = data[13] & 0x0F
+	}
+
+	header.MiscROMs = data[14] & 0x03
+	header.ExpansionDevice = data[15] & 0x3F
+}
+
+// The size is either 12 bits counted in units, or 2^E * (M*2+1) bytes when the MSB nibble is 0xF.
+// A size that doesn't fit is returned as the largest value, so the file is seen as truncated
+func nes2ROMSize(lsb uint8, msb uint8, unit uint) uint {
+	if msb == 0x0F {
+		exponent := uint(lsb >> 2)
+		multiplier := uint(lsb&0x03)*2 + 1
+		if exponent > bits.UintSize-4 {
+			return ^uint(0)
+		}
+		return (1 << exponent) * multiplier
+	}
+	return (uint(msb)<<8 | uint(lsb)) * unit
+}
+
+// The RAM sizes are shift counts: 64 << n bytes, or none when n is 0
+func nes2RAMSize(shift uint8) uint {
+	if shift == 0 {
+		return 0
+	}
+	return 64 << shift
 }
 
 type Cartridge struct {
+	Bus     *Bus
 	Loaded  bool
 	Header  Header
 	PRG_ROM []byte
-	CHR_ROM []byte
+	CHR_ROM []byte // Also holds the CHR-RAM
+	CHR_RAM bool   // The board has CHR-RAM instead of CHR-ROM
 	RAM     [0x2000]byte
+	Mapper  Mapper
+
+	// Of the PRG-ROM followed by the CHR-ROM
+	CRC32 uint32
+	SHA1  [20]byte
+	Game  *GameInfo // Entry of the game database, nil when unknown
 }
 
 func (cartridge *Cartridge) Read(address uint16) uint8 {
-	switch {
-	case address < 0x2000: // Used for the PPU bus
-		return cartridge.CHR_ROM[address]
-	case address < 0x8000: // Used for the CPU bus
-		return cartridge.RAM[address-0x6000]
-	case address >= 0x8000: // Used for the CPU bus
-		return cartridge.PRG_ROM[(address-0x8000)%uint16(len(cartridge.PRG_ROM))]
-	default:
-		return 0
+	if address < 0x2000 { // Used for the PPU bus
+		return cartridge.Mapper.ReadCHR(address)
 	}
+	return cartridge.Mapper.ReadPRG(address) // Used for the CPU bus
 }
 
 func (cartridge *Cartridge) Write(address uint16, value uint8) {
-	switch {
-	case address < 0x2000: // Used for the PPU bus
-		cartridge.CHR_ROM[address] = value
-	case address < 0x8000: // Used for the CPU bus
-		cartridge.RAM[address-0x6000] = value
+	if address < 0x2000 { // Used for the PPU bus
+		cartridge.Mapper.WriteCHR(address, value)
+		return
+	}
+	cartridge.Mapper.WritePRG(address, value) // Used for the CPU bus
+}
+
+// Boards without CHR-ROM have 8 KB of CHR-RAM, unless the header declares another size
+func (cartridge *Cartridge) allocateCHR() {
+	if cartridge.Header.CHR_ROM_size > 0 {
+		cartridge.CHR_ROM = make([]byte, cartridge.Header.CHR_ROM_size)
+		return
+	}
+	if cartridge.Header.CHR_RAM_size == 0 {
+		cartridge.Header.CHR_RAM_size = cartridge.Header.CHR_NVRAM_size
+	}
+	if cartridge.Header.CHR_RAM_size == 0 {
+		cartridge.Header.CHR_RAM_size = 0x2000
+	}
+	cartridge.CHR_ROM = make([]byte, cartridge.Header.CHR_RAM_size)
+	cartridge.CHR_RAM = true
+}
+
+// Saves the memory of the board that the game can change: the PRG-RAM and the CHR-RAM
+func (cartridge *Cartridge) saveMemory(writer io.Writer) error {
+	if _, err := writer.Write(cartridge.RAM[:]); err != nil {
+		return err
+	}
+	if cartridge.CHR_RAM {
+		if _, err := writer.Write(cartridge.CHR_ROM); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cartridge *Cartridge) loadMemory(reader io.Reader) error {
+	if _, err := io.ReadFull(reader, cartridge.RAM[:]); err != nil {
+		return err
+	}
+	if cartridge.CHR_RAM {
+		if _, err := io.ReadFull(reader, cartridge.CHR_ROM); err != nil {
+			return err
+		}
 	}
+	return nil
 }
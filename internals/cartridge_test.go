@@ -0,0 +1,155 @@
+package internals
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseNES2Header(t *testing.T) {
+	data := []byte{'N', 'E', 'S', 0x1A, 0x02, 0x01, 0x40, 0x1B, 0x51, 0x10, 0x70, 0x07, 0x01, 0x00, 0x01, 0x01}
+	var header Header
+	header.Mapper = 0x14
+	parseNES2Header(&header, data)
+
+	expected := Header{
+		NES2:            true,
+		Mapper:          0x114,
+		Submapper:       5,
+		PRG_ROM_size:    2 * 16 * 1024,
+		CHR_ROM_size:    (0x100 + 1) * 8 * 1024,
+		PRG_NVRAM_size:  8 * 1024,
+		CHR_RAM_size:    8 * 1024,
+		Timing:          TIMING_PAL,
+		ConsoleType:     CONSOLE_EXTENDED,
+		MiscROMs:        1,
+		ExpansionDevice: 1,
+	}
+	if header != expected {
+		t.Errorf("Wrong header:\n%+v\nexpected:\n%+v", header, expected)
+	}
+
+	// Exponent-multiplier notation: 2^4 * 3
+	if size := nes2ROMSize(0x11, 0x0F, 16*1024); size != 48 {
+		t.Error("Wrong exponent-multiplier size: ", size)
+	}
+}
+
+func TestLoadROMErrors(t *testing.T) {
+	header := []byte{'N', 'E', 'S', 0x1A, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	unsupported := append([]byte{}, header...)
+	unsupported[6] = 0xF0
+	unsupported[7] = 0xF0
+	unsupported = append(unsupported, make([]byte, 24*1024)...)
+
+	// Exponent 63 for both sizes, the sum would overflow
+	huge := []byte{'N', 'E', 'S', 0x1A, 0xFC, 0xFC, 0, 0x08, 0, 0xFF, 0, 0, 0, 0, 0, 0}
+	huge = append(huge, make([]byte, 512)...)
+
+	// MMC3 with 3 bytes of PRG-ROM, less than one 8 KB bank
+	partial := []byte{'N', 'E', 'S', 0x1A, 0x01, 0, 0x40, 0x08, 0, 0x0F, 0, 0, 0, 0, 0, 0, 0xEA, 0xEA, 0xEA}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"Empty", []byte{}, ErrBadMagic},
+		{"Bad magic", []byte("NES\x00 not a ROM"), ErrBadMagic},
+		{"Short header", header[:10], ErrTruncated},
+		{"Short ROM", append(append([]byte{}, header...), make([]byte, 16*1024)...), ErrTruncated},
+		{"Short NSF", []byte("NESM\x1A\x01"), ErrTruncated},
+		{"Unsupported mapper", unsupported, ErrUnsupportedMapper{0xFF}},
+		{"Huge NES 2.0 sizes", huge, ErrTruncated},
+		{"Partial PRG-ROM bank", partial, ErrPRGROMSize},
+	}
+
+	for _, test := range tests {
+		err := NewNES().LoadROM(bytes.NewReader(test.data))
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, expected %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		flags6   uint8
+		flags7   uint8
+		expected Header
+	}{
+		{"Horizontal", 0x00, 0x00, Header{}},
+		{"Vertical", 0x01, 0x00, Header{Mirroring: true}},
+		{"Battery", 0x02, 0x00, Header{PersistentRAM: true}},
+		{"Trainer", 0x04, 0x00, Header{Trainer: true}},
+		{"Four screen", 0x08, 0x00, Header{IgnoreMorriring: true}},
+		{"Mapper", 0x10, 0x40, Header{Mapper: 0x41}},
+		{"VS Unisystem", 0x00, 0x01, Header{VSUnisystem: true}},
+		{"PlayChoice-10", 0x00, 0x02, Header{PlayChoice: true}},
+		{"All flags", 0xFF, 0xF3, Header{Mirroring: true, PersistentRAM: true, Trainer: true, IgnoreMorriring: true, Mapper: 0xFF, VSUnisystem: true, PlayChoice: true}},
+	}
+
+	for _, test := range tests {
+		header := parseHeader([]byte{'N', 'E', 'S', 0x1A, 0, 0, test.flags6, test.flags7, 0, 0, 0, 0, 0, 0, 0, 0})
+		if header != test.expected {
+			t.Errorf("%s: got %+v, expected %+v", test.name, header, test.expected)
+		}
+	}
+}
+
+func TestLoadTrainer(t *testing.T) {
+	data := []byte{'N', 'E', 'S', 0x1A, 1, 1, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	trainer := bytes.Repeat([]byte{0x11}, 512)
+	prg := bytes.Repeat([]byte{0x22}, 16*1024)
+	chr := bytes.Repeat([]byte{0x33}, 8*1024)
+	data = append(append(append(data, trainer...), prg...), chr...)
+
+	nes := NewNES()
+	if err := nes.LoadROM(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if nes.Bus.Read(0x7000) != 0x11 || nes.Bus.Read(0x71FF) != 0x11 || nes.Bus.Read(0x7200) != 0 {
+		t.Error("Trainer not loaded at 0x7000")
+	}
+	if nes.Bus.Read(0x8000) != 0x22 || nes.Cartridge.Read(0x0000) != 0x33 {
+		t.Error("ROM offsets don't account for the trainer")
+	}
+
+	if err := NewNES().LoadROM(bytes.NewReader(data[:len(data)-1])); !errors.Is(err, ErrTruncated) {
+		t.Error("Truncated ROM with trainer not detected: ", err)
+	}
+}
+
+func TestLoadPatchedFile(t *testing.T) {
+	data, err := os.ReadFile("tests/nestest.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory := t.TempDir()
+	filename := filepath.Join(directory, "nestest.nes")
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Changes the first byte of the PRG-ROM
+	ips := []byte("PATCH\x00\x00\x10\x00\x01\x42EOF")
+	if err := os.WriteFile(filepath.Join(directory, "nestest.ips"), ips, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	nes := NewNES()
+	if err := nes.LoadFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if nes.Cartridge.PRG_ROM[0] != 0x42 {
+		t.Error("Same-named patch not applied")
+	}
+
+	nes = NewNES()
+	nes.Patches = []string{filepath.Join(directory, "missing.bps")}
+	if err := nes.LoadFile(filename); !os.IsNotExist(err) {
+		t.Error("Missing patch not reported: ", err)
+	}
+}
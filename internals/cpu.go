@@ -4,13 +4,6 @@ import (
 	"fmt"
 )
 
-const (
-	_ = iota
-	INTERRUPTS_NONE
-	INTERRUPTS_NMI
-	INTERRUPTS_IRQ
-)
-
 type CPU struct {
 	A uint8    // Accumulator
 	X uint8    // X index
@@ -28,9 +21,54 @@ type CPU struct {
 	PC         uint16 // Program counter
 	SP         uint8  // Stack pointer
 	CycleCount uint64
-	CycleDelay uint64
-	Interrupt  uint32
 	Bus        IBus
+
+	// Set when a KIL opcode jams the CPU. Only a reset recovers it
+	Halted *ErrCPUHalted
+
+	cycleState
+}
+
+// The instruction is executed one cycle at a time, with one bus access in each cycle
+// https://www.nesdev.com/6502_cpu.txt
+type cycleState struct {
+	instruction opcode
+	step        uint8  // Cycles done in the instruction, 0 when the next one has to be fetched
+	ready       uint8  // Step at which the address was computed, 0 until then
+	address     uint16 // Effective address
+	pointer     uint8  // Zero page operand of the indexed and indirect addressing modes
+	value       uint8  // Data latch
+	crossed     bool   // Indexing crossed a page
+
+	// The interrupt lines are sampled every cycle. An instruction checks the samples taken
+	// before its last cycle
+	nmiEdge      bool
+	irqLine      bool
+	nmiPending   bool
+	irqPending   bool
+	nmiPolled    bool
+	irqPolled    bool
+	interrupting bool // The BRK sequence is run for an NMI or IRQ
+
+	// The DMA halts the CPU and uses the bus in its place
+	// https://www.nesdev.org/wiki/DMA
+	oamDMA    bool
+	oamHalted bool
+	oamPage   uint8
+	oamIndex  uint8
+	oamValue  uint8
+	oamRead   bool
+	dmc       *DMC // Set while a sample is fetched
+	dmcCycle  uint8
+}
+
+type ErrCPUHalted struct {
+	Opcode uint8
+	PC     uint16
+}
+
+func (err ErrCPUHalted) Error() string {
+	return fmt.Sprintf("CPU halted by opcode $%02X at $%04X", err.Opcode, err.PC)
 }
 
 const (
@@ -56,272 +94,275 @@ type opcode struct {
 	Size           uint8
 	Cycles         uint8
 	PageCycles     uint8
-	run            func(*CPU, uint8, uint16, bool)
 	Name           string
-}
 
-//
+	run    func(*CPU, uint8, uint16, bool) // Reads or writes the operand on the last cycle
+	modify func(*CPU, uint8) uint8         // Read-modify-write instructions, returns the new value
+	branch func(*CPU) bool                 // Returns if the branch is taken
+	cycle  func(*CPU)                      // Stack and jump instructions, called for each cycle
+}
 
-// Missing illegal opcodes (undocumented) - https://www.nesdev.com/undocumented_opcodes.txt
+// Illegal opcodes (undocumented) - https://www.nesdev.org/wiki/CPU_unofficial_opcodes
+// https://www.nesdev.com/undocumented_opcodes.txt
 // http://www.6502.org/tutorials/6502opcodes.html#BRA
 // https://www.nesdev.com/6502.txt
 var instructions = [256]opcode{
-	{ID: 0x00, AddressingMode: Implied, Size: 1, Cycles: 7, PageCycles: 0, Name: "BRK", run: _BRK},
+	{ID: 0x00, AddressingMode: Implied, Size: 2, Cycles: 7, PageCycles: 0, Name: "BRK", cycle: _BRK},
 	{ID: 0x01, AddressingMode: IndirectX, Size: 2, Cycles: 6, PageCycles: 0, Name: "ORA", run: _ORA},
-	{}, // 0x02
-	{}, // 0x03
+	{ID: 0x02, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "KIL", run: _KIL},
+	{ID: 0x03, AddressingMode: IndirectX, Size: 2, Cycles: 8, PageCycles: 0, Name: "SLO", modify: _SLO},
 	{ID: 0x04, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "NOP", run: _NOP},
 	{ID: 0x05, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "ORA", run: _ORA},
-	{ID: 0x06, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "ASL", run: _ASL},
-	{}, // 0x07
-	{ID: 0x08, AddressingMode: Implied, Size: 1, Cycles: 3, PageCycles: 0, Name: "PHP", run: _PHP},
+	{ID: 0x06, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "ASL", modify: _ASL},
+	{ID: 0x07, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "SLO", modify: _SLO},
+	{ID: 0x08, AddressingMode: Implied, Size: 1, Cycles: 3, PageCycles: 0, Name: "PHP", cycle: _PHP},
 	{ID: 0x09, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "ORA", run: _ORA},
-	{ID: 0x0A, AddressingMode: Accumulator, Size: 1, Cycles: 2, PageCycles: 0, Name: "ASL", run: _ASL},
-	{}, // 0x0B
-	{ID: 0x0C, AddressingMode: Implied, Size: 3, Cycles: 4, PageCycles: 0, Name: "NOP", run: _NOP},
+	{ID: 0x0A, AddressingMode: Accumulator, Size: 1, Cycles: 2, PageCycles: 0, Name: "ASL", modify: _ASL},
+	{ID: 0x0B, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "ANC", run: _ANC},
+	{ID: 0x0C, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "NOP", run: _NOP},
 	{ID: 0x0D, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "ORA", run: _ORA},
-	{ID: 0x0E, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "ASL", run: _ASL},
-	{}, // 0x0F
-	{ID: 0x10, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BPL", run: _BPL},
+	{ID: 0x0E, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "ASL", modify: _ASL},
+	{ID: 0x0F, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "SLO", modify: _SLO},
+	{ID: 0x10, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BPL", branch: _BPL},
 	{ID: 0x11, AddressingMode: IndirectY, Size: 2, Cycles: 5, PageCycles: 1, Name: "ORA", run: _ORA},
-	{}, // 0x12
-	{}, // 0x13
+	{ID: 0x12, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "KIL", run: _KIL},
+	{ID: 0x13, AddressingMode: IndirectY, Size: 2, Cycles: 8, PageCycles: 0, Name: "SLO", modify: _SLO},
 	{ID: 0x14, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "NOP", run: _NOP},
 	{ID: 0x15, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "ORA", run: _ORA},
-	{ID: 0x16, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "ASL", run: _ASL},
-	{}, // 0x17
+	{ID: 0x16, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "ASL", modify: _ASL},
+	{ID: 0x17, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "SLO", modify: _SLO},
 	{ID: 0x18, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "CLC", run: _CLC},
 	{ID: 0x19, AddressingMode: AbsoluteY, Size: 3, Cycles: 4, PageCycles: 1, Name: "ORA", run: _ORA},
 	{ID: 0x1A, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "NOP", run: _NOP},
-	{}, // 0x1B
-	{ID: 0x1C, AddressingMode: Implied, Size: 3, Cycles: 5, PageCycles: 0, Name: "NOP", run: _NOP}, // Something with page crossing; Might need to read the arguments. Ignore for now
+	{ID: 0x1B, AddressingMode: AbsoluteY, Size: 3, Cycles: 7, PageCycles: 0, Name: "SLO", modify: _SLO},
+	{ID: 0x1C, AddressingMode: AbsoluteX, Size: 3, Cycles: 4, PageCycles: 1, Name: "NOP", run: _NOP},
 	{ID: 0x1D, AddressingMode: AbsoluteX, Size: 3, Cycles: 4, PageCycles: 1, Name: "ORA", run: _ORA},
-	{ID: 0x1E, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "ASL", run: _ASL},
-	{}, // 0x1F
-	{ID: 0x20, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "JSR", run: _JSR},
+	{ID: 0x1E, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "ASL", modify: _ASL},
+	{ID: 0x1F, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "SLO", modify: _SLO},
+	{ID: 0x20, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "JSR", cycle: _JSR},
 	{ID: 0x21, AddressingMode: IndirectX, Size: 2, Cycles: 6, PageCycles: 0, Name: "AND", run: _AND},
-	{}, // 0x22
-	{}, // 0x23
+	{ID: 0x22, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "KIL", run: _KIL},
+	{ID: 0x23, AddressingMode: IndirectX, Size: 2, Cycles: 8, PageCycles: 0, Name: "RLA", modify: _RLA},
 	{ID: 0x24, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "BIT", run: _BIT},
 	{ID: 0x25, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "AND", run: _AND},
-	{ID: 0x26, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "ROL", run: _ROL},
-	{}, // 0x27
-	{ID: 0x28, AddressingMode: Implied, Size: 1, Cycles: 4, PageCycles: 0, Name: "PLP", run: _PLP},
+	{ID: 0x26, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "ROL", modify: _ROL},
+	{ID: 0x27, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "RLA", modify: _RLA},
+	{ID: 0x28, AddressingMode: Implied, Size: 1, Cycles: 4, PageCycles: 0, Name: "PLP", cycle: _PLP},
 	{ID: 0x29, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "AND", run: _AND},
-	{ID: 0x2A, AddressingMode: Accumulator, Size: 1, Cycles: 2, PageCycles: 0, Name: "ROL", run: _ROL},
-	{}, // 0x2B
+	{ID: 0x2A, AddressingMode: Accumulator, Size: 1, Cycles: 2, PageCycles: 0, Name: "ROL", modify: _ROL},
+	{ID: 0x2B, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "ANC", run: _ANC},
 	{ID: 0x2C, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "BIT", run: _BIT},
 	{ID: 0x2D, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "AND", run: _AND},
-	{ID: 0x2E, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "ROL", run: _ROL},
-	{}, // 0x2F
-	{ID: 0x30, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BMI", run: _BMI},
+	{ID: 0x2E, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "ROL", modify: _ROL},
+	{ID: 0x2F, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "RLA", modify: _RLA},
+	{ID: 0x30, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BMI", branch: _BMI},
 	{ID: 0x31, AddressingMode: IndirectY, Size: 2, Cycles: 5, PageCycles: 1, Name: "AND", run: _AND},
-	{}, // 0x32
-	{}, // 0x33
+	{ID: 0x32, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "KIL", run: _KIL},
+	{ID: 0x33, AddressingMode: IndirectY, Size: 2, Cycles: 8, PageCycles: 0, Name: "RLA", modify: _RLA},
 	{ID: 0x34, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "NOP", run: _NOP},
 	{ID: 0x35, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "AND", run: _AND},
-	{ID: 0x36, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "ROL", run: _ROL},
-	{}, // 0x37
+	{ID: 0x36, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "ROL", modify: _ROL},
+	{ID: 0x37, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "RLA", modify: _RLA},
 	{ID: 0x38, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "SEC", run: _SEC},
 	{ID: 0x39, AddressingMode: AbsoluteY, Size: 3, Cycles: 4, PageCycles: 1, Name: "AND", run: _AND},
 	{ID: 0x3A, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "NOP", run: _NOP},
-	{}, // 0x3B
-	{ID: 0x3C, AddressingMode: Implied, Size: 3, Cycles: 5, PageCycles: 0, Name: "NOP", run: _NOP}, // Something with page crossing; Might need to read the arguments. Ignore for now
+	{ID: 0x3B, AddressingMode: AbsoluteY, Size: 3, Cycles: 7, PageCycles: 0, Name: "RLA", modify: _RLA},
+	{ID: 0x3C, AddressingMode: AbsoluteX, Size: 3, Cycles: 4, PageCycles: 1, Name: "NOP", run: _NOP},
 	{ID: 0x3D, AddressingMode: AbsoluteX, Size: 3, Cycles: 4, PageCycles: 1, Name: "AND", run: _AND},
-	{ID: 0x3E, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "ROL", run: _ROL},
-	{}, // 0x3F
-	{ID: 0x40, AddressingMode: Implied, Size: 1, Cycles: 6, PageCycles: 0, Name: "RTI", run: _RTI},
+	{ID: 0x3E, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "ROL", modify: _ROL},
+	{ID: 0x3F, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "RLA", modify: _RLA},
+	{ID: 0x40, AddressingMode: Implied, Size: 1, Cycles: 6, PageCycles: 0, Name: "RTI", cycle: _RTI},
 	{ID: 0x41, AddressingMode: IndirectX, Size: 2, Cycles: 6, PageCycles: 0, Name: "EOR", run: _EOR},
-	{}, // 0x42
-	{}, // 0x43
+	{ID: 0x42, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "KIL", run: _KIL},
+	{ID: 0x43, AddressingMode: IndirectX, Size: 2, Cycles: 8, PageCycles: 0, Name: "SRE", modify: _SRE},
 	{ID: 0x44, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "NOP", run: _NOP},
 	{ID: 0x45, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "EOR", run: _EOR},
-	{ID: 0x46, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "LSR", run: _LSR},
-	{}, // 0x47
-	{ID: 0x48, AddressingMode: Implied, Size: 1, Cycles: 3, PageCycles: 0, Name: "PHA", run: _PHA},
+	{ID: 0x46, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "LSR", modify: _LSR},
+	{ID: 0x47, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "SRE", modify: _SRE},
+	{ID: 0x48, AddressingMode: Implied, Size: 1, Cycles: 3, PageCycles: 0, Name: "PHA", cycle: _PHA},
 	{ID: 0x49, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "EOR", run: _EOR},
-	{ID: 0x4A, AddressingMode: Accumulator, Size: 1, Cycles: 2, PageCycles: 0, Name: "LSR", run: _LSR},
-	{}, // 0x4B
-	{ID: 0x4C, AddressingMode: Absolute, Size: 3, Cycles: 3, PageCycles: 0, Name: "JMP", run: _JMP},
+	{ID: 0x4A, AddressingMode: Accumulator, Size: 1, Cycles: 2, PageCycles: 0, Name: "LSR", modify: _LSR},
+	{ID: 0x4B, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "ALR", run: _ALR},
+	{ID: 0x4C, AddressingMode: Absolute, Size: 3, Cycles: 3, PageCycles: 0, Name: "JMP", cycle: _JMP},
 	{ID: 0x4D, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "EOR", run: _EOR},
-	{ID: 0x4E, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "LSR", run: _LSR},
-	{}, // 0x4F
-	{ID: 0x50, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BVC", run: _BVC},
+	{ID: 0x4E, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "LSR", modify: _LSR},
+	{ID: 0x4F, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "SRE", modify: _SRE},
+	{ID: 0x50, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BVC", branch: _BVC},
 	{ID: 0x51, AddressingMode: IndirectY, Size: 2, Cycles: 5, PageCycles: 1, Name: "EOR", run: _EOR},
-	{}, // 0x52
-	{}, // 0x53
+	{ID: 0x52, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "KIL", run: _KIL},
+	{ID: 0x53, AddressingMode: IndirectY, Size: 2, Cycles: 8, PageCycles: 0, Name: "SRE", modify: _SRE},
 	{ID: 0x54, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "NOP", run: _NOP},
 	{ID: 0x55, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "EOR", run: _EOR},
-	{ID: 0x56, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "LSR", run: _LSR},
-	{}, // 0x57
+	{ID: 0x56, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "LSR", modify: _LSR},
+	{ID: 0x57, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "SRE", modify: _SRE},
 	{ID: 0x58, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "CLI", run: _CLI},
 	{ID: 0x59, AddressingMode: AbsoluteY, Size: 3, Cycles: 4, PageCycles: 1, Name: "EOR", run: _EOR},
 	{ID: 0x5A, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "NOP", run: _NOP},
-	{}, // 0x5B
-	{ID: 0x5C, AddressingMode: Implied, Size: 3, Cycles: 5, PageCycles: 0, Name: "NOP", run: _NOP}, // Something with page crossing; Might need to read the arguments. Ignore for now
+	{ID: 0x5B, AddressingMode: AbsoluteY, Size: 3, Cycles: 7, PageCycles: 0, Name: "SRE", modify: _SRE},
+	{ID: 0x5C, AddressingMode: AbsoluteX, Size: 3, Cycles: 4, PageCycles: 1, Name: "NOP", run: _NOP},
 	{ID: 0x5D, AddressingMode: AbsoluteX, Size: 3, Cycles: 4, PageCycles: 1, Name: "EOR", run: _EOR},
-	{ID: 0x5E, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "LSR", run: _LSR},
-	{}, // 0x5F
-	{ID: 0x60, AddressingMode: Implied, Size: 1, Cycles: 6, PageCycles: 0, Name: "RTS", run: _RTS},
+	{ID: 0x5E, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "LSR", modify: _LSR},
+	{ID: 0x5F, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "SRE", modify: _SRE},
+	{ID: 0x60, AddressingMode: Implied, Size: 1, Cycles: 6, PageCycles: 0, Name: "RTS", cycle: _RTS},
 	{ID: 0x61, AddressingMode: IndirectX, Size: 2, Cycles: 6, PageCycles: 0, Name: "ADC", run: _ADC},
-	{}, // 0x62
-	{}, // 0x63
+	{ID: 0x62, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "KIL", run: _KIL},
+	{ID: 0x63, AddressingMode: IndirectX, Size: 2, Cycles: 8, PageCycles: 0, Name: "RRA", modify: _RRA},
 	{ID: 0x64, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "NOP", run: _NOP},
 	{ID: 0x65, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "ADC", run: _ADC},
-	{ID: 0x66, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "ROR", run: _ROR},
-	{}, // 0x67
-	{ID: 0x68, AddressingMode: Implied, Size: 1, Cycles: 4, PageCycles: 0, Name: "PLA", run: _PLA},
+	{ID: 0x66, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "ROR", modify: _ROR},
+	{ID: 0x67, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "RRA", modify: _RRA},
+	{ID: 0x68, AddressingMode: Implied, Size: 1, Cycles: 4, PageCycles: 0, Name: "PLA", cycle: _PLA},
 	{ID: 0x69, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "ADC", run: _ADC},
-	{ID: 0x6A, AddressingMode: Accumulator, Size: 1, Cycles: 2, PageCycles: 0, Name: "ROR", run: _ROR},
-	{}, // 0x6B
-	{ID: 0x6C, AddressingMode: Indirect, Size: 3, Cycles: 5, PageCycles: 0, Name: "JMP", run: _JMP},
+	{ID: 0x6A, AddressingMode: Accumulator, Size: 1, Cycles: 2, PageCycles: 0, Name: "ROR", modify: _ROR},
+	{ID: 0x6B, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "ARR", run: _ARR},
+	{ID: 0x6C, AddressingMode: Indirect, Size: 3, Cycles: 5, PageCycles: 0, Name: "JMP", cycle: _JMP},
 	{ID: 0x6D, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "ADC", run: _ADC},
-	{ID: 0x6E, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "ROR", run: _ROR},
-	{}, // 0x6F
-	{ID: 0x70, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BVS", run: _BVS},
+	{ID: 0x6E, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "ROR", modify: _ROR},
+	{ID: 0x6F, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "RRA", modify: _RRA},
+	{ID: 0x70, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BVS", branch: _BVS},
 	{ID: 0x71, AddressingMode: IndirectY, Size: 2, Cycles: 5, PageCycles: 1, Name: "ADC", run: _ADC},
-	{}, // 0x72
-	{}, // 0x73
+	{ID: 0x72, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "KIL", run: _KIL},
+	{ID: 0x73, AddressingMode: IndirectY, Size: 2, Cycles: 8, PageCycles: 0, Name: "RRA", modify: _RRA},
 	{ID: 0x74, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "NOP", run: _NOP},
 	{ID: 0x75, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "ADC", run: _ADC},
-	{ID: 0x76, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "ROR", run: _ROR},
-	{}, // 0x77
+	{ID: 0x76, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "ROR", modify: _ROR},
+	{ID: 0x77, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "RRA", modify: _RRA},
 	{ID: 0x78, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "SEI", run: _SEI},
 	{ID: 0x79, AddressingMode: AbsoluteY, Size: 3, Cycles: 4, PageCycles: 1, Name: "ADC", run: _ADC},
 	{ID: 0x7A, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "NOP", run: _NOP},
-	{}, // 0x7B
-	{ID: 0x7C, AddressingMode: Implied, Size: 3, Cycles: 5, PageCycles: 0, Name: "NOP", run: _NOP}, // Something with page crossing; Might need to read the arguments. Ignore for now
+	{ID: 0x7B, AddressingMode: AbsoluteY, Size: 3, Cycles: 7, PageCycles: 0, Name: "RRA", modify: _RRA},
+	{ID: 0x7C, AddressingMode: AbsoluteX, Size: 3, Cycles: 4, PageCycles: 1, Name: "NOP", run: _NOP},
 	{ID: 0x7D, AddressingMode: AbsoluteX, Size: 3, Cycles: 4, PageCycles: 1, Name: "ADC", run: _ADC},
-	{ID: 0x7E, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "ROR", run: _ROR},
-	{}, // 0x7F
+	{ID: 0x7E, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "ROR", modify: _ROR},
+	{ID: 0x7F, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "RRA", modify: _RRA},
 	{ID: 0x80, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "NOP", run: _NOP},
 	{ID: 0x81, AddressingMode: IndirectX, Size: 2, Cycles: 6, PageCycles: 0, Name: "STA", run: _STA},
 	{ID: 0x82, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "NOP", run: _NOP},
-	{}, // 0x83
+	{ID: 0x83, AddressingMode: IndirectX, Size: 2, Cycles: 6, PageCycles: 0, Name: "SAX", run: _SAX},
 	{ID: 0x84, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "STY", run: _STY},
 	{ID: 0x85, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "STA", run: _STA},
 	{ID: 0x86, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "STX", run: _STX},
-	{}, // 0x87
+	{ID: 0x87, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "SAX", run: _SAX},
 	{ID: 0x88, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "DEY", run: _DEY},
 	{ID: 0x89, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "NOP", run: _NOP},
 	{ID: 0x8A, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "TXA", run: _TXA},
-	{}, // 0x8B
+	{ID: 0x8B, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "ANE", run: _ANE},
 	{ID: 0x8C, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "STY", run: _STY},
 	{ID: 0x8D, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "STA", run: _STA},
 	{ID: 0x8E, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "STX", run: _STX},
-	{}, // 0x8F
-	{ID: 0x90, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BCC", run: _BCC},
+	{ID: 0x8F, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "SAX", run: _SAX},
+	{ID: 0x90, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BCC", branch: _BCC},
 	{ID: 0x91, AddressingMode: IndirectY, Size: 2, Cycles: 6, PageCycles: 0, Name: "STA", run: _STA},
-	{}, // 0x92
-	{}, // 0x93
+	{ID: 0x92, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "KIL", run: _KIL},
+	{ID: 0x93, AddressingMode: IndirectY, Size: 2, Cycles: 6, PageCycles: 0, Name: "AHX", run: _AHX},
 	{ID: 0x94, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "STY", run: _STY},
 	{ID: 0x95, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "STA", run: _STA},
 	{ID: 0x96, AddressingMode: ZeroPageY, Size: 2, Cycles: 4, PageCycles: 0, Name: "STX", run: _STX},
-	{}, // 0x97
+	{ID: 0x97, AddressingMode: ZeroPageY, Size: 2, Cycles: 4, PageCycles: 0, Name: "SAX", run: _SAX},
 	{ID: 0x98, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "TYA", run: _TYA},
 	{ID: 0x99, AddressingMode: AbsoluteY, Size: 3, Cycles: 5, PageCycles: 0, Name: "STA", run: _STA},
 	{ID: 0x9A, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "TXS", run: _TXS},
-	{}, // 0x9B
-	{}, // 0x9C
+	{ID: 0x9B, AddressingMode: AbsoluteY, Size: 3, Cycles: 5, PageCycles: 0, Name: "TAS", run: _TAS},
+	{ID: 0x9C, AddressingMode: AbsoluteX, Size: 3, Cycles: 5, PageCycles: 0, Name: "SHY", run: _SHY},
 	{ID: 0x9D, AddressingMode: AbsoluteX, Size: 3, Cycles: 5, PageCycles: 0, Name: "STA", run: _STA},
-	{}, // 0x9E
-	{}, // 0x9F
+	{ID: 0x9E, AddressingMode: AbsoluteY, Size: 3, Cycles: 5, PageCycles: 0, Name: "SHX", run: _SHX},
+	{ID: 0x9F, AddressingMode: AbsoluteY, Size: 3, Cycles: 5, PageCycles: 0, Name: "AHX", run: _AHX},
 	{ID: 0xA0, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "LDY", run: _LDY},
 	{ID: 0xA1, AddressingMode: IndirectX, Size: 2, Cycles: 6, PageCycles: 0, Name: "LDA", run: _LDA},
 	{ID: 0xA2, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "LDX", run: _LDX},
-	{}, // 0xA3
+	{ID: 0xA3, AddressingMode: IndirectX, Size: 2, Cycles: 6, PageCycles: 0, Name: "LAX", run: _LAX},
 	{ID: 0xA4, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "LDY", run: _LDY},
 	{ID: 0xA5, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "LDA", run: _LDA},
 	{ID: 0xA6, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "LDX", run: _LDX},
-	{}, // 0xA7
+	{ID: 0xA7, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "LAX", run: _LAX},
 	{ID: 0xA8, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "TAY", run: _TAY},
 	{ID: 0xA9, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "LDA", run: _LDA},
 	{ID: 0xAA, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "TAX", run: _TAX},
-	{}, // 0xAB
+	{ID: 0xAB, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "LXA", run: _LXA},
 	{ID: 0xAC, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "LDY", run: _LDY},
 	{ID: 0xAD, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "LDA", run: _LDA},
 	{ID: 0xAE, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "LDX", run: _LDX},
-	{}, // 0xAF
-	{ID: 0xB0, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BCS", run: _BCS},
+	{ID: 0xAF, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "LAX", run: _LAX},
+	{ID: 0xB0, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BCS", branch: _BCS},
 	{ID: 0xB1, AddressingMode: IndirectY, Size: 2, Cycles: 5, PageCycles: 1, Name: "LDA", run: _LDA},
-	{}, // 0xB2
-	{}, // 0xB3
+	{ID: 0xB2, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "KIL", run: _KIL},
+	{ID: 0xB3, AddressingMode: IndirectY, Size: 2, Cycles: 5, PageCycles: 1, Name: "LAX", run: _LAX},
 	{ID: 0xB4, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "LDY", run: _LDY},
 	{ID: 0xB5, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "LDA", run: _LDA},
 	{ID: 0xB6, AddressingMode: ZeroPageY, Size: 2, Cycles: 4, PageCycles: 0, Name: "LDX", run: _LDX},
-	{}, // 0xB7
+	{ID: 0xB7, AddressingMode: ZeroPageY, Size: 2, Cycles: 4, PageCycles: 0, Name: "LAX", run: _LAX},
 	{ID: 0xB8, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "CLV", run: _CLV},
 	{ID: 0xB9, AddressingMode: AbsoluteY, Size: 3, Cycles: 4, PageCycles: 1, Name: "LDA", run: _LDA},
 	{ID: 0xBA, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "TSX", run: _TSX},
-	{}, // 0xBB
+	{ID: 0xBB, AddressingMode: AbsoluteY, Size: 3, Cycles: 4, PageCycles: 1, Name: "LAS", run: _LAS},
 	{ID: 0xBC, AddressingMode: AbsoluteX, Size: 3, Cycles: 4, PageCycles: 1, Name: "LDY", run: _LDY},
 	{ID: 0xBD, AddressingMode: AbsoluteX, Size: 3, Cycles: 4, PageCycles: 1, Name: "LDA", run: _LDA},
 	{ID: 0xBE, AddressingMode: AbsoluteY, Size: 3, Cycles: 4, PageCycles: 1, Name: "LDX", run: _LDX},
-	{}, // 0xBF
+	{ID: 0xBF, AddressingMode: AbsoluteY, Size: 3, Cycles: 4, PageCycles: 1, Name: "LAX", run: _LAX},
 	{ID: 0xC0, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "CPY", run: _CPY},
 	{ID: 0xC1, AddressingMode: IndirectX, Size: 2, Cycles: 6, PageCycles: 0, Name: "CMP", run: _CMP},
 	{ID: 0xC2, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "NOP", run: _NOP},
-	{}, // 0xC3
+	{ID: 0xC3, AddressingMode: IndirectX, Size: 2, Cycles: 8, PageCycles: 0, Name: "DCP", modify: _DCP},
 	{ID: 0xC4, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "CPY", run: _CPY},
 	{ID: 0xC5, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "CMP", run: _CMP},
-	{ID: 0xC6, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "DEC", run: _DEC},
-	{}, // 0xC7
+	{ID: 0xC6, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "DEC", modify: _DEC},
+	{ID: 0xC7, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "DCP", modify: _DCP},
 	{ID: 0xC8, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "INY", run: _INY},
 	{ID: 0xC9, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "CMP", run: _CMP},
 	{ID: 0xCA, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "DEX", run: _DEX},
-	{}, // 0xCB
+	{ID: 0xCB, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "AXS", run: _AXS},
 	{ID: 0xCC, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "CPY", run: _CPY},
 	{ID: 0xCD, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "CMP", run: _CMP},
-	{ID: 0xCE, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "DEC", run: _DEC},
-	{}, // 0xCF
-	{ID: 0xD0, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BNE", run: _BNE},
+	{ID: 0xCE, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "DEC", modify: _DEC},
+	{ID: 0xCF, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "DCP", modify: _DCP},
+	{ID: 0xD0, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BNE", branch: _BNE},
 	{ID: 0xD1, AddressingMode: IndirectY, Size: 2, Cycles: 5, PageCycles: 1, Name: "CMP", run: _CMP},
-	{}, // 0xD2
-	{}, // 0xD3
+	{ID: 0xD2, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "KIL", run: _KIL},
+	{ID: 0xD3, AddressingMode: IndirectY, Size: 2, Cycles: 8, PageCycles: 0, Name: "DCP", modify: _DCP},
 	{ID: 0xD4, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "NOP", run: _NOP},
 	{ID: 0xD5, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "CMP", run: _CMP},
-	{ID: 0xD6, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "DEC", run: _DEC},
-	{}, // 0xD7
+	{ID: 0xD6, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "DEC", modify: _DEC},
+	{ID: 0xD7, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "DCP", modify: _DCP},
 	{ID: 0xD8, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "CLD", run: _CLD},
 	{ID: 0xD9, AddressingMode: AbsoluteY, Size: 3, Cycles: 4, PageCycles: 1, Name: "CMP", run: _CMP},
 	{ID: 0xDA, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "NOP", run: _NOP},
-	{}, // 0xDB
-	{ID: 0xDC, AddressingMode: Implied, Size: 3, Cycles: 5, PageCycles: 0, Name: "NOP", run: _NOP}, // Something with page crossing; Might need to read the arguments. Ignore for now
+	{ID: 0xDB, AddressingMode: AbsoluteY, Size: 3, Cycles: 7, PageCycles: 0, Name: "DCP", modify: _DCP},
+	{ID: 0xDC, AddressingMode: AbsoluteX, Size: 3, Cycles: 4, PageCycles: 1, Name: "NOP", run: _NOP},
 	{ID: 0xDD, AddressingMode: AbsoluteX, Size: 3, Cycles: 4, PageCycles: 1, Name: "CMP", run: _CMP},
-	{ID: 0xDE, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "DEC", run: _DEC},
-	{}, // 0xDF
+	{ID: 0xDE, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "DEC", modify: _DEC},
+	{ID: 0xDF, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "DCP", modify: _DCP},
 	{ID: 0xE0, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "CPX", run: _CPX},
 	{ID: 0xE1, AddressingMode: IndirectX, Size: 2, Cycles: 6, PageCycles: 0, Name: "SBC", run: _SBC},
 	{ID: 0xE2, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "NOP", run: _NOP},
-	{}, // 0xE3
+	{ID: 0xE3, AddressingMode: IndirectX, Size: 2, Cycles: 8, PageCycles: 0, Name: "ISC", modify: _ISC},
 	{ID: 0xE4, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "CPX", run: _CPX},
 	{ID: 0xE5, AddressingMode: ZeroPage, Size: 2, Cycles: 3, PageCycles: 0, Name: "SBC", run: _SBC},
-	{ID: 0xE6, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "INC", run: _INC},
-	{}, // 0xE7
+	{ID: 0xE6, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "INC", modify: _INC},
+	{ID: 0xE7, AddressingMode: ZeroPage, Size: 2, Cycles: 5, PageCycles: 0, Name: "ISC", modify: _ISC},
 	{ID: 0xE8, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "INX", run: _INX},
 	{ID: 0xE9, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "SBC", run: _SBC},
 	{ID: 0xEA, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "NOP", run: _NOP},
-	{}, // 0xEB
+	{ID: 0xEB, AddressingMode: Immediate, Size: 2, Cycles: 2, PageCycles: 0, Name: "SBC", run: _SBC},
 	{ID: 0xEC, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "CPX", run: _CPX},
 	{ID: 0xED, AddressingMode: Absolute, Size: 3, Cycles: 4, PageCycles: 0, Name: "SBC", run: _SBC},
-	{ID: 0xE6, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "INC", run: _INC},
-	{}, // 0xEF
-	{ID: 0xF0, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BEQ", run: _BEQ},
+	{ID: 0xEE, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "INC", modify: _INC},
+	{ID: 0xEF, AddressingMode: Absolute, Size: 3, Cycles: 6, PageCycles: 0, Name: "ISC", modify: _ISC},
+	{ID: 0xF0, AddressingMode: Relative, Size: 2, Cycles: 2, PageCycles: 0, Name: "BEQ", branch: _BEQ},
 	{ID: 0xF1, AddressingMode: IndirectY, Size: 2, Cycles: 5, PageCycles: 1, Name: "SBC", run: _SBC},
-	{}, // 0xF2
-	{}, // 0xF3
+	{ID: 0xF2, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "KIL", run: _KIL},
+	{ID: 0xF3, AddressingMode: IndirectY, Size: 2, Cycles: 8, PageCycles: 0, Name: "ISC", modify: _ISC},
 	{ID: 0xF4, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "NOP", run: _NOP},
 	{ID: 0xF5, AddressingMode: ZeroPageX, Size: 2, Cycles: 4, PageCycles: 0, Name: "SBC", run: _SBC},
-	{ID: 0xF6, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "INC", run: _INC},
-	{}, // 0xF7
+	{ID: 0xF6, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "INC", modify: _INC},
+	{ID: 0xF7, AddressingMode: ZeroPageX, Size: 2, Cycles: 6, PageCycles: 0, Name: "ISC", modify: _ISC},
 	{ID: 0xF8, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "SED", run: _SED},
 	{ID: 0xF9, AddressingMode: AbsoluteY, Size: 3, Cycles: 4, PageCycles: 1, Name: "SBC", run: _SBC},
 	{ID: 0xFA, AddressingMode: Implied, Size: 1, Cycles: 2, PageCycles: 0, Name: "NOP", run: _NOP},
-	{}, // 0xFB
-	{ID: 0xFC, AddressingMode: Implied, Size: 3, Cycles: 5, PageCycles: 0, Name: "NOP", run: _NOP}, // Something with page crossing; Might need to read the arguments. Ignore for now
+	{ID: 0xFB, AddressingMode: AbsoluteY, Size: 3, Cycles: 7, PageCycles: 0, Name: "ISC", modify: _ISC},
+	{ID: 0xFC, AddressingMode: AbsoluteX, Size: 3, Cycles: 4, PageCycles: 1, Name: "NOP", run: _NOP},
 	{ID: 0xFD, AddressingMode: AbsoluteX, Size: 3, Cycles: 4, PageCycles: 1, Name: "SBC", run: _SBC},
-	{ID: 0xFE, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "INC", run: _INC},
-	{}, // 0xFF
+	{ID: 0xFE, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "INC", modify: _INC},
+	{ID: 0xFF, AddressingMode: AbsoluteX, Size: 3, Cycles: 7, PageCycles: 0, Name: "ISC", modify: _ISC},
 }
 
 // Returns the address asociated with an opcode and if a memory page was crossed (where applicable, default is false)
@@ -367,62 +408,361 @@ func (cpu *CPU) getAddress(instruction opcode) (uint16, bool) {
 
 var DEBUG bool = false
 
+// Runs one cycle, which is one bus access of the DMA, the current instruction or an interrupt
+func (cpu *CPU) Cycle() {
+	if cpu.Halted != nil {
+		// The DMA still gets the bus while the CPU is jammed
+		cpu.CycleCount++
+		cpu.dmaCycle()
+		return
+	}
+	cpu.CycleCount++
+	cpu.pollInterrupts()
+
+	if cpu.dmaCycle() {
+		return
+	}
+	if cpu.step == 0 {
+		cpu.fetchInstruction()
+		return
+	}
+
+	cpu.step++
+	instruction := cpu.instruction
+	switch {
+	case instruction.cycle != nil:
+		instruction.cycle(cpu)
+	case instruction.branch != nil:
+		cpu.branch()
+	default:
+		if cpu.ready == 0 {
+			if !cpu.computeAddress() {
+				return
+			}
+			cpu.ready = cpu.step
+		}
+		cpu.operate(cpu.step - cpu.ready)
+	}
+}
+
+// Runs the cycles of the next instruction, or of the interrupt sequence
 func (cpu *CPU) Step() (uint64, opcode) {
 	var startingCycles uint64 = cpu.CycleCount
-
-	op := cpu.Bus.Read(cpu.PC)
-	instruction := instructions[op]
-	if instruction.run == nil {
-		instruction = instructions[0x1A] // NOP
+	for cpu.step == 0 && cpu.Halted == nil {
+		cpu.Cycle()
 	}
+	for cpu.step != 0 && cpu.Halted == nil {
+		cpu.Cycle()
+	}
+	return cpu.CycleCount - startingCycles, cpu.instruction
+}
 
-	address, pageCycle := cpu.getAddress(instruction)
+// The interrupts are polled at the end of the second to last cycle of the instruction
+// https://www.nesdev.org/wiki/CPU_interrupts
+func (cpu *CPU) pollInterrupts() {
+	cpu.nmiPolled = cpu.nmiPending
+	if cpu.nmiEdge {
+		cpu.nmiPending = true
+		cpu.nmiEdge = false
+	}
+	cpu.irqPolled = cpu.irqPending
+	cpu.irqPending = cpu.irqLine && cpu.P.I == 0
+	cpu.irqLine = false
+}
+
+func (cpu *CPU) fetchInstruction() {
+	cpu.step = 1
+	cpu.ready = 0
+	cpu.crossed = false
+
+	// The opcode is read and ignored, the BRK sequence pushes the address of this instruction
+	if cpu.nmiPolled || cpu.irqPolled {
+		cpu.Bus.Read(cpu.PC)
+		cpu.instruction = instructions[0x00]
+		cpu.interrupting = true
+		return
+	}
 
 	if DEBUG {
-		instructionBytes := fmt.Sprintf("%2x", cpu.Bus.Read(cpu.PC))
-		for i := 1; i < int(instruction.Size); i++ {
-			instructionBytes += fmt.Sprintf(" %2x", cpu.Bus.Read(cpu.PC+uint16(i)))
+		cpu.trace()
+	}
+	cpu.instruction = instructions[cpu.fetchOperand()]
+	cpu.interrupting = false
+}
+
+func (cpu *CPU) fetchOperand() uint8 {
+	value := cpu.Bus.Read(cpu.PC)
+	cpu.PC++
+	return value
+}
+
+func (cpu *CPU) finish() {
+	cpu.step = 0
+}
+
+func (cpu *CPU) indexRegister() uint8 {
+	switch cpu.instruction.AddressingMode {
+	case ZeroPageY, AbsoluteY, IndirectY:
+		return cpu.Y
+	default:
+		return cpu.X
+	}
+}
+
+// The index is added to the low byte first, fixing the high byte takes another cycle
+func (cpu *CPU) indexAddress() {
+	base := cpu.address
+	cpu.address += uint16(cpu.indexRegister())
+	cpu.crossed = base&0xFF00 != cpu.address&0xFF00
+}
+
+// Reads the address before its high byte is fixed. Only the instructions that read their operand
+// skip this cycle when no page was crossed. Returns if the cycle was used
+func (cpu *CPU) fixAddress() bool {
+	if !cpu.crossed && cpu.instruction.PageCycles != 0 {
+		return false
+	}
+	if cpu.crossed {
+		cpu.Bus.Read(cpu.address - 0x100)
+	} else {
+		cpu.Bus.Read(cpu.address)
+	}
+	return true
+}
+
+// Computes the effective address. Returns true when the address is ready and the cycle was not
+// used by the addressing mode
+func (cpu *CPU) computeAddress() bool {
+	switch cpu.instruction.AddressingMode {
+	case Immediate:
+		cpu.address = cpu.PC
+		cpu.PC++
+	case ZeroPage:
+		if cpu.step == 2 {
+			cpu.address = uint16(cpu.fetchOperand())
+			return false
+		}
+	case ZeroPageX, ZeroPageY:
+		switch cpu.step {
+		case 2:
+			cpu.pointer = cpu.fetchOperand()
+			return false
+		case 3:
+			cpu.Bus.Read(uint16(cpu.pointer))
+			cpu.address = uint16(cpu.pointer + cpu.indexRegister())
+			return false
 		}
-		if instruction.Size < 3 {
-			instructionBytes += "\t"
+	case Absolute:
+		switch cpu.step {
+		case 2:
+			cpu.address = uint16(cpu.fetchOperand())
+			return false
+		case 3:
+			cpu.address |= uint16(cpu.fetchOperand()) << 8
+			return false
 		}
+	case AbsoluteX, AbsoluteY:
+		switch cpu.step {
+		case 2:
+			cpu.address = uint16(cpu.fetchOperand())
+			return false
+		case 3:
+			cpu.address |= uint16(cpu.fetchOperand()) << 8
+			cpu.indexAddress()
+			return false
+		case 4:
+			return !cpu.fixAddress()
+		}
+	case IndirectX:
+		switch cpu.step {
+		case 2:
+			cpu.pointer = cpu.fetchOperand()
+			return false
+		case 3:
+			cpu.Bus.Read(uint16(cpu.pointer))
+			cpu.pointer += cpu.X
+			return false
+		case 4:
+			cpu.address = uint16(cpu.Bus.Read(uint16(cpu.pointer)))
+			return false
+		case 5:
+			cpu.address |= uint16(cpu.Bus.Read(uint16(cpu.pointer+1))) << 8
+			return false
+		}
+	case IndirectY:
+		switch cpu.step {
+		case 2:
+			cpu.pointer = cpu.fetchOperand()
+			return false
+		case 3:
+			cpu.address = uint16(cpu.Bus.Read(uint16(cpu.pointer)))
+			return false
+		case 4:
+			cpu.address |= uint16(cpu.Bus.Read(uint16(cpu.pointer+1))) << 8
+			cpu.indexAddress()
+			return false
+		case 5:
+			return !cpu.fixAddress()
+		}
+	}
+	return true
+}
+
+// The read-modify-write instructions write the unmodified value back, then the new one
+func (cpu *CPU) operate(step uint8) {
+	instruction := cpu.instruction
+	switch {
+	case instruction.modify == nil:
+		if instruction.AddressingMode == Implied {
+			cpu.Bus.Read(cpu.PC)
+		}
+		instruction.run(cpu, instruction.AddressingMode, cpu.address, cpu.crossed)
+		cpu.finish()
+	case instruction.AddressingMode == Accumulator:
+		cpu.Bus.Read(cpu.PC)
+		cpu.A = instruction.modify(cpu, cpu.A)
+		cpu.finish()
+	case step == 0:
+		cpu.value = cpu.Bus.Read(cpu.address)
+	case step == 1:
+		cpu.Bus.Write(cpu.address, cpu.value)
+		cpu.value = instruction.modify(cpu, cpu.value)
+	default:
+		cpu.Bus.Write(cpu.address, cpu.value)
+		cpu.finish()
+	}
+}
 
-		stack := ""
-		for i := 1; i < 10 && i+int(cpu.SP) <= 0xFF; i++ {
-			stack += fmt.Sprintf("%2x ", cpu.Bus.Read(uint16(i)+uint16(cpu.SP)+0x100))
+// The offset is added to the low byte of the PC first, fixing the high byte takes another cycle
+func (cpu *CPU) branch() {
+	switch cpu.step {
+	case 2:
+		cpu.value = cpu.fetchOperand()
+		if !cpu.instruction.branch(cpu) {
+			cpu.finish()
+		}
+	case 3:
+		cpu.Bus.Read(cpu.PC)
+		cpu.address = cpu.PC + uint16(int8(cpu.value))
+		// A taken branch doesn't see an IRQ raised during its second cycle
+		if cpu.irqPending && !cpu.irqPolled {
+			cpu.irqPending = false
+		}
+		if cpu.address&0xFF00 == cpu.PC&0xFF00 {
+			cpu.PC = cpu.address
+			cpu.finish()
+		} else {
+			cpu.PC = cpu.PC&0xFF00 | cpu.address&0x00FF
 		}
+	case 4:
+		cpu.Bus.Read(cpu.PC)
+		cpu.PC = cpu.address
+		cpu.finish()
+	}
+}
 
-		fmt.Printf("%4x\t%v\t%v\tA:%2x X:%2x Y:%2x P:%x SP:%2x ADDR:%4x CYC:%d\tSTK:%v\n", cpu.PC, instructionBytes, instruction.Name, cpu.A, cpu.X, cpu.Y, cpu.GetFlags(), cpu.SP, address, cpu.CycleCount, stack)
+// The OAM DMA copies a page to $2004, reading on the even cycles and writing on the odd ones. The
+// DMC DMA fetches a sample byte, it takes precedence
+func (cpu *CPU) dmaCycle() bool {
+	if cpu.dmc == nil && !cpu.oamDMA {
+		return false
+	}
+	// The CPU can only be halted on a read cycle, the DMA waits for the writes to be done
+	halted := cpu.dmcCycle > 0 || (cpu.oamDMA && cpu.oamHalted)
+	if !halted && cpu.Halted == nil && cpu.writeCycle() {
+		return false
+	}
 
+	if cpu.dmc != nil {
+		cpu.dmcCycle++
+		if cpu.dmcCycle == 4 {
+			cpu.dmc.loadSample(cpu.Bus.Read(cpu.dmc.CurrentAddress))
+			cpu.dmc = nil
+			cpu.dmcCycle = 0
+		}
+		return true
+	}
+	if !cpu.oamDMA {
+		return false
 	}
 
-	cpu.PC += uint16(instruction.Size)
-	cpu.CycleCount += uint64(instruction.Cycles)
-	if pageCycle {
-		cpu.CycleCount += uint64(instruction.PageCycles)
+	switch {
+	case !cpu.oamHalted: // Waiting for the CPU to stop
+		cpu.oamHalted = true
+	case cpu.CycleCount%2 == 0:
+		cpu.oamValue = cpu.Bus.Read(uint16(cpu.oamPage)<<8 | uint16(cpu.oamIndex))
+		cpu.oamRead = true
+	case cpu.oamRead: // Otherwise it is an alignment cycle
+		cpu.Bus.Write(0x2004, cpu.oamValue)
+		cpu.oamRead = false
+		cpu.oamIndex++
+		if cpu.oamIndex == 0 {
+			cpu.oamDMA = false
+		}
 	}
+	return true
+}
+
+// The instructions that write their operand on their last cycle
+var storeInstructions = map[string]bool{
+	"STA": true, "STX": true, "STY": true, "SAX": true, "AHX": true, "TAS": true, "SHX": true, "SHY": true,
+}
 
-	instruction.run(cpu, instruction.AddressingMode, address, pageCycle)
+// Returns if the next cycle of the instruction writes to the bus
+func (cpu *CPU) writeCycle() bool {
+	if cpu.step == 0 {
+		return false
+	}
+	next := cpu.step + 1
+	instruction := cpu.instruction
+	switch {
+	case instruction.Name == "BRK": // Also the interrupts, pushing the PC and the flags
+		return next >= 3 && next <= 5
+	case instruction.Name == "JSR":
+		return next == 4 || next == 5
+	case instruction.Name == "PHA" || instruction.Name == "PHP":
+		return next == 3
+	case instruction.modify != nil && instruction.AddressingMode != Accumulator:
+		// The unmodified value is written back, then the new one
+		return next >= instruction.Cycles-1
+	case storeInstructions[instruction.Name]:
+		return next == instruction.Cycles
+	}
+	return false
+}
 
-	return cpu.CycleCount - startingCycles, instruction
+func (cpu *CPU) startOAMDMA(page uint8) {
+	cpu.oamDMA = true
+	cpu.oamHalted = false
+	cpu.oamPage = page
+	cpu.oamIndex = 0
+	cpu.oamRead = false
 }
 
-func (cpu *CPU) Cycle() {
-	if cpu.CycleDelay == 0 {
-		switch cpu.Interrupt {
-		case INTERRUPTS_NONE:
-			cpu.CycleDelay, _ = cpu.Step()
-		case INTERRUPTS_NMI:
-			cpu._NMI()
-			cpu.Interrupt = INTERRUPTS_NONE
-			cpu.CycleDelay = 7
-		case INTERRUPTS_IRQ:
-			cpu._IRQ()
-			cpu.Interrupt = INTERRUPTS_NONE
-			cpu.CycleDelay = 7
-		}
+func (cpu *CPU) startDMCDMA(dmc *DMC) {
+	if cpu.dmc == nil {
+		cpu.dmc = dmc
 	}
-	cpu.CycleDelay--
+}
+
+func (cpu *CPU) trace() {
+	instruction := instructions[cpu.Bus.Read(cpu.PC)]
+	address, _ := cpu.getAddress(instruction)
+
+	instructionBytes := fmt.Sprintf("%2x", cpu.Bus.Read(cpu.PC))
+	for i := 1; i < int(instruction.Size); i++ {
+		instructionBytes += fmt.Sprintf(" %2x", cpu.Bus.Read(cpu.PC+uint16(i)))
+	}
+	if instruction.Size < 3 {
+		instructionBytes += "\t"
+	}
+
+	stack := ""
+	for i := 1; i < 10 && i+int(cpu.SP) <= 0xFF; i++ {
+		stack += fmt.Sprintf("%2x ", cpu.Bus.Read(uint16(i)+uint16(cpu.SP)+0x100))
+	}
+
+	fmt.Printf("%4x\t%v\t%v\tA:%2x X:%2x Y:%2x P:%x SP:%2x ADDR:%4x CYC:%d\tSTK:%v\n", cpu.PC, instructionBytes, instruction.Name, cpu.A, cpu.X, cpu.Y, cpu.GetFlags(), cpu.SP, address, cpu.CycleCount-1, stack)
 }
 
 func (cpu *CPU) SetFlags(flags uint8) {
@@ -460,7 +800,8 @@ func (cpu *CPU) PowerUp() {
 	cpu.CycleCount = 7 // Warming up
 	cpu.P.I = 1
 
-	cpu.Interrupt = INTERRUPTS_NONE
+	cpu.Halted = nil
+	cpu.cycleState = cycleState{}
 }
 
 // https://wiki.nesdev.org/w/index.php?title=CPU_power_up_state
@@ -469,7 +810,8 @@ func (cpu *CPU) Reset() {
 	cpu.SP = 0xFD
 	cpu.P.I = 1
 
-	cpu.Interrupt = INTERRUPTS_NONE
+	cpu.Halted = nil
+	cpu.cycleState = cycleState{}
 }
 
 func (cpu *CPU) setZero(value uint8) {
@@ -512,34 +854,18 @@ func (cpu *CPU) PopAddress() uint16 {
 	return cpu.Bus.ReadAddress(uint16(cpu.SP-1) + 0x100)
 }
 
+// Called on the falling edge of the NMI line
 func (cpu *CPU) InterruptNMI() {
-	cpu.Interrupt = INTERRUPTS_NMI
+	cpu.nmiEdge = true
 }
 
+// Called for every cycle while the IRQ line is asserted
 func (cpu *CPU) InterruptIRQ() {
-	if cpu.P.I == 0 {
-		cpu.Interrupt = INTERRUPTS_IRQ
-	}
-}
-
-func (cpu *CPU) _NMI() {
-	cpu.PushAddress(cpu.PC)
-	cpu.Push(cpu.GetFlags())
-	cpu.PC = cpu.Bus.ReadAddress(0xFFFA)
-	cpu.P.I = 1
-	cpu.CycleCount += 7
+	cpu.irqLine = true
 }
 
-func (cpu *CPU) _IRQ() {
-	cpu.PushAddress(cpu.PC)
-	cpu.Push(cpu.GetFlags())
-	cpu.PC = cpu.Bus.ReadAddress(0xFFFE)
-	cpu.P.I = 1
-	cpu.CycleCount += 7
-}
-
-func _ADC(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	var src uint8 = cpu.Bus.Read(address)
+// Adds with carry, SBC is the same as adding the complement of its operand
+func (cpu *CPU) add(src uint8) {
 	var temp uint16 = uint16(src) + uint16(cpu.A) + uint16(cpu.P.C)
 	cpu.setSign(uint8(temp))
 	cpu.setZero(uint8(temp))
@@ -560,6 +886,20 @@ func _ADC(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
 	cpu.A = uint8(temp)
 }
 
+func (cpu *CPU) compare(register uint8, src uint8) {
+	if register >= src {
+		cpu.P.C = 1
+	} else {
+		cpu.P.C = 0
+	}
+	cpu.setSign(register - src)
+	cpu.setZero(register - src)
+}
+
+func _ADC(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	cpu.add(cpu.Bus.Read(address))
+}
+
 func _AND(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
 	var src uint8 = cpu.Bus.Read(address)
 	cpu.A &= src
@@ -567,59 +907,24 @@ func _AND(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
 	cpu.setZero(cpu.A)
 }
 
-func _ASL(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	var src uint8
-	if addressingMode == Accumulator {
-		src = cpu.A
-	} else {
-		src = cpu.Bus.Read(address)
-	}
-
-	if src&0x80 != 0 {
-		cpu.P.C = 1
-	} else {
-		cpu.P.C = 0
-	}
-
-	src <<= 1
-	cpu.setSign(src)
-	cpu.setZero(src)
-
-	if addressingMode == Accumulator {
-		cpu.A = src
-	} else {
-		cpu.Bus.Write(address, src)
-	}
+func _ASL(cpu *CPU, value uint8) uint8 {
+	cpu.P.C = value >> 7
+	value <<= 1
+	cpu.setSign(value)
+	cpu.setZero(value)
+	return value
 }
 
-func _BCC(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	if cpu.P.C == 0 {
-		cpu.PC = address
-		cpu.CycleCount++
-		if pageCycle {
-			cpu.CycleCount++
-		}
-	}
+func _BCC(cpu *CPU) bool {
+	return cpu.P.C == 0
 }
 
-func _BCS(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	if cpu.P.C == 1 {
-		cpu.PC = address
-		cpu.CycleCount++
-		if pageCycle {
-			cpu.CycleCount++
-		}
-	}
+func _BCS(cpu *CPU) bool {
+	return cpu.P.C == 1
 }
 
-func _BEQ(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	if cpu.P.Z == 1 {
-		cpu.PC = address
-		cpu.CycleCount++
-		if pageCycle {
-			cpu.CycleCount++
-		}
-	}
+func _BEQ(cpu *CPU) bool {
+	return cpu.P.Z == 1
 }
 
 func _BIT(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
@@ -634,62 +939,57 @@ func _BIT(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
 	}
 }
 
-func _BMI(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	if cpu.P.S == 1 {
-		cpu.PC = address
-		cpu.CycleCount++
-		if pageCycle {
-			cpu.CycleCount++
-		}
-	}
-}
-
-func _BNE(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	if cpu.P.Z == 0 {
-		cpu.PC = address
-		cpu.CycleCount++
-		if pageCycle {
-			cpu.CycleCount++
-		}
-	}
+func _BMI(cpu *CPU) bool {
+	return cpu.P.S == 1
 }
 
-func _BPL(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	if cpu.P.S == 0 {
-		cpu.PC = address
-		cpu.CycleCount++
-		if pageCycle {
-			cpu.CycleCount++
-		}
-	}
+func _BNE(cpu *CPU) bool {
+	return cpu.P.Z == 0
 }
 
-func _BRK(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	cpu.PushAddress(cpu.PC)
-	cpu.P.B = 1
-	cpu.Push(cpu.GetFlags())
-	cpu.P.I = 1
-	cpu.PC = cpu.Bus.ReadAddress(0xFFFE)
+func _BPL(cpu *CPU) bool {
+	return cpu.P.S == 0
 }
 
-func _BVC(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	if cpu.P.V == 0 {
-		cpu.PC = address
-		cpu.CycleCount++
-		if pageCycle {
-			cpu.CycleCount++
+// Also used for the NMI and IRQ, which don't skip the byte after the opcode and don't set B
+func _BRK(cpu *CPU) {
+	switch cpu.step {
+	case 2:
+		cpu.Bus.Read(cpu.PC)
+		if !cpu.interrupting {
+			cpu.PC++
+		}
+	case 3:
+		cpu.Push(uint8(cpu.PC >> 8))
+	case 4:
+		cpu.Push(uint8(cpu.PC))
+	case 5:
+		if cpu.interrupting {
+			cpu.Push(cpu.GetFlags() &^ 0x10)
+		} else {
+			cpu.Push(cpu.GetFlags() | 0x10)
 		}
+		// An NMI raised until now takes over the sequence
+		cpu.address = 0xFFFE
+		if cpu.nmiPending {
+			cpu.nmiPending = false
+			cpu.address = 0xFFFA
+		}
+	case 6:
+		cpu.P.I = 1
+		cpu.value = cpu.Bus.Read(cpu.address)
+	case 7:
+		cpu.PC = uint16(cpu.value) | uint16(cpu.Bus.Read(cpu.address+1))<<8
+		cpu.finish()
 	}
 }
 
-func _BVS(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	if cpu.P.V == 1 {
-		cpu.PC = address
-		cpu.CycleCount++
-		if pageCycle {
-			cpu.CycleCount++
-		}
-	}
+func _BVC(cpu *CPU) bool {
+	return cpu.P.V == 0
+}
+
+func _BVS(cpu *CPU) bool {
+	return cpu.P.V == 1
 }
 
 func _CLC(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
@@ -745,11 +1045,11 @@ func _CPY(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
 	cpu.setZero(uint8(src))
 }
 
-func _DEC(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	var src uint8 = cpu.Bus.Read(address) - 1
-	cpu.setSign(src)
-	cpu.setZero(src)
-	cpu.Bus.Write(address, src)
+func _DEC(cpu *CPU, value uint8) uint8 {
+	value--
+	cpu.setSign(value)
+	cpu.setZero(value)
+	return value
 }
 
 func _DEX(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
@@ -771,12 +1071,11 @@ func _EOR(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
 	cpu.setZero(cpu.A)
 }
 
-func _INC(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	var src uint8 = cpu.Bus.Read(address)
-	src++
-	cpu.setSign(src)
-	cpu.setZero(src)
-	cpu.Bus.Write(address, src)
+func _INC(cpu *CPU, value uint8) uint8 {
+	value++
+	cpu.setSign(value)
+	cpu.setZero(value)
+	return value
 }
 
 func _INX(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
@@ -791,14 +1090,40 @@ func _INY(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
 	cpu.setZero(cpu.Y)
 }
 
-func _JMP(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	cpu.PC = address
+// The indirect address is read without crossing the page
+func _JMP(cpu *CPU) {
+	switch cpu.step {
+	case 2:
+		cpu.value = cpu.fetchOperand()
+	case 3:
+		cpu.address = uint16(cpu.value) | uint16(cpu.Bus.Read(cpu.PC))<<8
+		if cpu.instruction.AddressingMode == Absolute {
+			cpu.PC = cpu.address
+			cpu.finish()
+		}
+	case 4:
+		cpu.value = cpu.Bus.Read(cpu.address)
+	case 5:
+		cpu.PC = uint16(cpu.value) | uint16(cpu.Bus.Read(cpu.address&0xFF00|uint16(uint8(cpu.address)+1)))<<8
+		cpu.finish()
+	}
 }
 
-func _JSR(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	cpu.PC--
-	cpu.PushAddress(cpu.PC)
-	cpu.PC = address
+// The address of the last byte of the instruction is pushed
+func _JSR(cpu *CPU) {
+	switch cpu.step {
+	case 2:
+		cpu.value = cpu.fetchOperand()
+	case 3:
+		cpu.Bus.Read(uint16(cpu.SP) + 0x100)
+	case 4:
+		cpu.Push(uint8(cpu.PC >> 8))
+	case 5:
+		cpu.Push(uint8(cpu.PC))
+	case 6:
+		cpu.PC = uint16(cpu.value) | uint16(cpu.Bus.Read(cpu.PC))<<8
+		cpu.finish()
+	}
 }
 
 func _LDA(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
@@ -819,28 +1144,18 @@ func _LDY(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
 	cpu.setZero(cpu.Y)
 }
 
-func _LSR(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	var src uint8
-	if addressingMode == Accumulator {
-		src = cpu.A
-	} else {
-		src = cpu.Bus.Read(address)
-	}
-
-	cpu.P.C = src & 0x01
-	src >>= 1
-	cpu.setSign(src)
-	cpu.setZero(src)
-
-	if addressingMode == Accumulator {
-		cpu.A = src
-	} else {
-		cpu.Bus.Write(address, src)
-	}
+func _LSR(cpu *CPU, value uint8) uint8 {
+	cpu.P.C = value & 0x01
+	value >>= 1
+	cpu.setSign(value)
+	cpu.setZero(value)
+	return value
 }
 
 func _NOP(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-
+	if addressingMode != Implied {
+		cpu.Bus.Read(address)
+	}
 }
 
 func _ORA(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
@@ -849,100 +1164,112 @@ func _ORA(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
 	cpu.setZero(cpu.A)
 }
 
-func _PHA(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	cpu.Push(cpu.A)
-}
-
-func _PHP(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	cpu.Push(cpu.GetFlags())
+func _PHA(cpu *CPU) {
+	switch cpu.step {
+	case 2:
+		cpu.Bus.Read(cpu.PC)
+	case 3:
+		cpu.Push(cpu.A)
+		cpu.finish()
+	}
 }
 
-func _PLA(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	cpu.A = cpu.Pop()
-	cpu.setSign(cpu.A)
-	cpu.setZero(cpu.A)
+func _PHP(cpu *CPU) {
+	switch cpu.step {
+	case 2:
+		cpu.Bus.Read(cpu.PC)
+	case 3:
+		cpu.Push(cpu.GetFlags())
+		cpu.finish()
+	}
 }
 
-func _PLP(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	cpu.SetFlags(cpu.Pop()&0xEF | 0x20)
+func _PLA(cpu *CPU) {
+	switch cpu.step {
+	case 2:
+		cpu.Bus.Read(cpu.PC)
+	case 3:
+		cpu.Bus.Read(uint16(cpu.SP) + 0x100)
+	case 4:
+		cpu.A = cpu.Pop()
+		cpu.setSign(cpu.A)
+		cpu.setZero(cpu.A)
+		cpu.finish()
+	}
 }
 
-func _ROL(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	var src uint8
-	if addressingMode == Accumulator {
-		src = cpu.A
-	} else {
-		src = cpu.Bus.Read(address)
+func _PLP(cpu *CPU) {
+	switch cpu.step {
+	case 2:
+		cpu.Bus.Read(cpu.PC)
+	case 3:
+		cpu.Bus.Read(uint16(cpu.SP) + 0x100)
+	case 4:
+		cpu.SetFlags(cpu.Pop()&0xEF | 0x20)
+		cpu.finish()
 	}
+}
 
-	var carry uint8 = (src >> 7) & 1
+func _ROL(cpu *CPU, value uint8) uint8 {
+	var carry uint8 = (value >> 7) & 1
 
-	src <<= 1
-	src |= cpu.P.C
+	value <<= 1
+	value |= cpu.P.C
 
 	cpu.P.C = carry
-	cpu.setSign(src)
-	cpu.setZero(src)
-
-	if addressingMode == Accumulator {
-		cpu.A = src
-	} else {
-		cpu.Bus.Write(address, src)
-	}
+	cpu.setSign(value)
+	cpu.setZero(value)
+	return value
 }
 
-func _ROR(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	var src uint8
-	if addressingMode == Accumulator {
-		src = cpu.A
-	} else {
-		src = cpu.Bus.Read(address)
-	}
+func _ROR(cpu *CPU, value uint8) uint8 {
+	var carry uint8 = value & 1
 
-	var carry uint8 = src & 1
-
-	src >>= 1
-	src |= (cpu.P.C << 7)
+	value >>= 1
+	value |= (cpu.P.C << 7)
 
 	cpu.P.C = carry
-	cpu.setSign(src)
-	cpu.setZero(src)
-
-	if addressingMode == Accumulator {
-		cpu.A = src
-	} else {
-		cpu.Bus.Write(address, src)
+	cpu.setSign(value)
+	cpu.setZero(value)
+	return value
+}
+
+func _RTI(cpu *CPU) {
+	switch cpu.step {
+	case 2:
+		cpu.Bus.Read(cpu.PC)
+	case 3:
+		cpu.Bus.Read(uint16(cpu.SP) + 0x100)
+	case 4:
+		cpu.SetFlags(cpu.Pop())
+	case 5:
+		cpu.value = cpu.Pop()
+	case 6:
+		cpu.PC = uint16(cpu.value) | uint16(cpu.Pop())<<8
+		cpu.finish()
 	}
 }
 
-func _RTI(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	cpu.SetFlags(cpu.Pop())
-	cpu.PC = cpu.PopAddress()
-}
-
-func _RTS(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	cpu.PC = cpu.PopAddress() + 1
+// The address pulled is the last byte of the JSR, the PC is incremented on the last cycle
+func _RTS(cpu *CPU) {
+	switch cpu.step {
+	case 2:
+		cpu.Bus.Read(cpu.PC)
+	case 3:
+		cpu.Bus.Read(uint16(cpu.SP) + 0x100)
+	case 4:
+		cpu.value = cpu.Pop()
+	case 5:
+		cpu.PC = uint16(cpu.value) | uint16(cpu.Pop())<<8
+	case 6:
+		cpu.Bus.Read(cpu.PC)
+		cpu.PC++
+		cpu.finish()
+	}
 }
 
 func _SBC(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
-	var src uint8 = cpu.Bus.Read(address)
-	var temp uint16 = uint16(cpu.A) - uint16(src) - 1 + uint16(cpu.P.C)
-	cpu.setSign(uint8(temp))
-	cpu.setZero(uint8(temp))
-
-	if temp < 0x100 {
-		cpu.P.C = 1
-	} else {
-		cpu.P.C = 0
-	}
-
-	if (cpu.A^uint8(temp))&0x80 != 0 && (cpu.A^src)&0x80 != 0 {
-		cpu.P.V = 1
-	} else {
-		cpu.P.V = 0
-	}
-
-	cpu.A = uint8(temp)
+	cpu.add(^cpu.Bus.Read(address))
 }
 
 func _SEC(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
@@ -1002,3 +1329,152 @@ func _TYA(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
 	cpu.setSign(cpu.A)
 	cpu.setZero(cpu.A)
 }
+
+// Illegal opcodes
+
+func _AHX(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	cpu.storeHigh(address, cpu.Y, cpu.A&cpu.X)
+}
+
+func _ALR(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	cpu.A &= cpu.Bus.Read(address)
+	cpu.P.C = cpu.A & 0x01
+	cpu.A >>= 1
+	cpu.setSign(cpu.A)
+	cpu.setZero(cpu.A)
+}
+
+func _ANC(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	cpu.A &= cpu.Bus.Read(address)
+	cpu.setSign(cpu.A)
+	cpu.setZero(cpu.A)
+	cpu.P.C = cpu.P.S
+}
+
+// Unstable, the value ORed with A depends on the chip. 0xEE is the most common one
+func _ANE(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	cpu.A = (cpu.A | 0xEE) & cpu.X & cpu.Bus.Read(address)
+	cpu.setSign(cpu.A)
+	cpu.setZero(cpu.A)
+}
+
+func _ARR(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	cpu.A &= cpu.Bus.Read(address)
+	cpu.A = cpu.A>>1 | cpu.P.C<<7
+	cpu.setSign(cpu.A)
+	cpu.setZero(cpu.A)
+	cpu.P.C = (cpu.A >> 6) & 1
+	cpu.P.V = ((cpu.A >> 6) ^ (cpu.A >> 5)) & 1
+}
+
+func _AXS(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	var src uint8 = cpu.Bus.Read(address)
+	value := cpu.A & cpu.X
+	cpu.compare(value, src)
+	cpu.X = value - src
+}
+
+func _DCP(cpu *CPU, value uint8) uint8 {
+	value--
+	cpu.compare(cpu.A, value)
+	return value
+}
+
+func _ISC(cpu *CPU, value uint8) uint8 {
+	value++
+	cpu.add(^value)
+	return value
+}
+
+// The CPU stops fetching instructions, the PC is left on the opcode
+func _KIL(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	cpu.PC--
+	cpu.Halted = &ErrCPUHalted{Opcode: cpu.Bus.Read(cpu.PC), PC: cpu.PC}
+}
+
+func _LAS(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	cpu.SP &= cpu.Bus.Read(address)
+	cpu.A = cpu.SP
+	cpu.X = cpu.SP
+	cpu.setSign(cpu.A)
+	cpu.setZero(cpu.A)
+}
+
+func _LAX(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	cpu.A = cpu.Bus.Read(address)
+	cpu.X = cpu.A
+	cpu.setSign(cpu.A)
+	cpu.setZero(cpu.A)
+}
+
+// Unstable, the value ORed with A depends on the chip. 0xEE is the most common one
+func _LXA(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	cpu.A = (cpu.A | 0xEE) & cpu.Bus.Read(address)
+	cpu.X = cpu.A
+	cpu.setSign(cpu.A)
+	cpu.setZero(cpu.A)
+}
+
+func _RLA(cpu *CPU, value uint8) uint8 {
+	var carry uint8 = value >> 7
+	value = value<<1 | cpu.P.C
+	cpu.P.C = carry
+	cpu.A &= value
+	cpu.setSign(cpu.A)
+	cpu.setZero(cpu.A)
+	return value
+}
+
+func _RRA(cpu *CPU, value uint8) uint8 {
+	var carry uint8 = value & 1
+	value = value>>1 | cpu.P.C<<7
+	cpu.P.C = carry
+	cpu.add(value)
+	return value
+}
+
+func _SAX(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	cpu.Bus.Write(address, cpu.A&cpu.X)
+}
+
+func _SHX(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	cpu.storeHigh(address, cpu.Y, cpu.X)
+}
+
+func _SHY(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	cpu.storeHigh(address, cpu.X, cpu.Y)
+}
+
+func _SLO(cpu *CPU, value uint8) uint8 {
+	cpu.P.C = value >> 7
+	value <<= 1
+	cpu.A |= value
+	cpu.setSign(cpu.A)
+	cpu.setZero(cpu.A)
+	return value
+}
+
+func _SRE(cpu *CPU, value uint8) uint8 {
+	cpu.P.C = value & 1
+	value >>= 1
+	cpu.A ^= value
+	cpu.setSign(cpu.A)
+	cpu.setZero(cpu.A)
+	return value
+}
+
+func _TAS(cpu *CPU, addressingMode uint8, address uint16, pageCycle bool) {
+	cpu.SP = cpu.A & cpu.X
+	cpu.storeHigh(address, cpu.Y, cpu.SP)
+}
+
+// Stores the value ANDed with the high byte of the base address plus 1. When the index crosses
+// a page, the high byte of the address is replaced by the stored value
+func (cpu *CPU) storeHigh(address uint16, index uint8, value uint8) {
+	high := uint8((address-uint16(index))>>8) + 1
+	value &= high
+	if (address-uint16(index))&0xFF00 != address&0xFF00 {
+		address = uint16(value)<<8 | address&0x00FF
+	}
+	cpu.Bus.Write(address, value)
+}
@@ -1,6 +1,7 @@
 package internals
 
 import (
+	"fmt"
 	"os"
 	"testing"
 )
@@ -53,25 +54,34 @@ func TestCPUInstructions(t *testing.T) {
 	cpu.PowerUp()
 	cpu.PC = 0xC000
 
+	// The official opcodes are done at cycle 14940, the illegal ones at 26554
 	for cpu.CycleCount < 14940 {
 		cpu.Cycle()
 	}
+	if cpu.PC != 0xC6C4 || cpu.A != 0x55 || cpu.Y != 0x53 || cpu.GetFlags() != 0x24 || cpu.SP != 0xF9 || cpu.CycleCount != 14940 {
+		t.Error("Failed CPU instructions test at the official opcodes")
+	}
+	for cpu.CycleCount < 26554 {
+		cpu.Cycle()
+	}
 
 	errorCode1 := cpu.Bus.Read(0x2)
 	errorCode2 := cpu.Bus.Read(0x3)
 
-	if cpu.PC != 0xC6C4 || cpu.A != 0x55 || cpu.Y != 0x53 || cpu.GetFlags() != 0x24 || cpu.SP != 0xF9 || cpu.CycleCount != 14940 || errorCode1 != 0 || errorCode2 != 0 {
+	if cpu.PC != 0xC66E || cpu.A != 0x00 || cpu.X != 0xFF || cpu.Y != 0x15 || cpu.GetFlags() != 0x27 || cpu.SP != 0xFD || cpu.CycleCount != 26554 || errorCode1 != 0 || errorCode2 != 0 {
 		t.Error("Failed CPU instructions test. Fail codes: ", errorCode1, errorCode2)
 	}
 }
 
 func TestNonMockCPUInstructions(t *testing.T) {
 	nes := NewNES()
-	nes.LoadFile("tests/nestest.nes")
+	if err := nes.LoadFile("tests/nestest.nes"); err != nil {
+		t.Fatal(err)
+	}
 
 	nes.CPU.PC = 0xC000
 
-	for nes.CPU.CycleCount < 14940 {
+	for nes.CPU.CycleCount < 26554 {
 		nes.CPU.Cycle()
 	}
 
@@ -82,3 +92,192 @@ func TestNonMockCPUInstructions(t *testing.T) {
 		t.Error("Failed CPU instructions test. Fail codes: ", errorCode1, errorCode2)
 	}
 }
+
+func TestCPUHalt(t *testing.T) {
+	memory := &BusMock{}
+	memory.RAM[0x8000] = 0xE8 // INX
+	memory.RAM[0x8001] = 0x02 // KIL
+	memory.RAM[0x8002] = 0xE8 // INX
+	memory.WriteAddress(0xFFFC, 0x8000)
+
+	var cpu *CPU = &CPU{}
+	cpu.Bus = memory
+	cpu.PowerUp()
+	for i := 0; i < 100; i++ {
+		cpu.Cycle()
+	}
+	cpu.InterruptNMI()
+	cpu.Cycle()
+
+	if cpu.Halted == nil || *cpu.Halted != (ErrCPUHalted{Opcode: 0x02, PC: 0x8001}) || cpu.X != 1 || cpu.PC != 0x8001 {
+		t.Error("KIL did not halt the CPU: ", cpu.Halted, cpu.X, cpu.PC)
+	}
+
+	// The DMC still fetches its samples
+	memory.RAM[0xC000] = 0x42
+	dmc := &DMC{CurrentAddress: 0xC000, BytesRemaining: 1, BufferEmpty: true}
+	cpu.startDMCDMA(dmc)
+	for i := 0; i < 4; i++ {
+		cpu.Cycle()
+	}
+	if dmc.BufferEmpty || dmc.SampleBuffer != 0x42 {
+		t.Error("DMC sample not fetched while the CPU is halted")
+	}
+
+	cpu.Reset()
+	if cpu.Halted != nil || cpu.PC != 0x8000 {
+		t.Error("Reset did not recover the CPU")
+	}
+}
+
+// The cycles of each instruction come from its bus accesses, they have to match the table
+func TestCPUCycles(t *testing.T) {
+	for _, crossed := range []bool{false, true} {
+		for _, instruction := range instructions {
+			memory := &BusMock{}
+			memory.RAM[0x8000] = instruction.ID
+			memory.WriteAddress(0x8001, 0x0001)
+			memory.WriteAddress(0x0001, 0x0001) // Indirect pointer
+			memory.WriteAddress(0xFFFC, 0x8000)
+
+			var cpu *CPU = &CPU{}
+			cpu.Bus = memory
+			cpu.PowerUp()
+			if crossed {
+				cpu.X = 0xFF
+				cpu.Y = 0xFF
+			}
+
+			expected := uint64(instruction.Cycles)
+			if crossed {
+				expected += uint64(instruction.PageCycles)
+			}
+			if instruction.branch != nil && instruction.branch(cpu) {
+				expected++
+			}
+
+			cycles, _ := cpu.Step()
+			if cycles != expected {
+				t.Errorf("%s (0x%02X) took %d cycles instead of %d, page crossed: %v", instruction.Name, instruction.ID, cycles, expected, crossed)
+			}
+		}
+	}
+}
+
+type RecordingBusMock struct {
+	BusMock
+	Accesses []string
+}
+
+func (memoryMock *RecordingBusMock) Read(address uint16) uint8 {
+	memoryMock.Accesses = append(memoryMock.Accesses, fmt.Sprintf("R %04X", address))
+	return memoryMock.BusMock.Read(address)
+}
+
+func (memoryMock *RecordingBusMock) Write(address uint16, value uint8) {
+	memoryMock.Accesses = append(memoryMock.Accesses, fmt.Sprintf("W %04X %02X", address, value))
+	memoryMock.BusMock.Write(address, value)
+}
+
+func TestCPUBusAccesses(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  []uint8
+		accesses []string
+	}{
+		{"LDA $02FF,X", []uint8{0xBD, 0xFF, 0x02}, []string{"R 8000", "R 8001", "R 8002", "R 0200", "R 0300"}},
+		{"LDA $0200,X", []uint8{0xBD, 0x00, 0x02}, []string{"R 8000", "R 8001", "R 8002", "R 0201"}},
+		{"STA $0200,X", []uint8{0x9D, 0x00, 0x02}, []string{"R 8000", "R 8001", "R 8002", "R 0201", "W 0201 00"}},
+		{"INC $10", []uint8{0xE6, 0x10}, []string{"R 8000", "R 8001", "R 0010", "W 0010 41", "W 0010 42"}},
+		{"ASL $10,X", []uint8{0x16, 0x10}, []string{"R 8000", "R 8001", "R 0010", "R 0011", "W 0011 00", "W 0011 00"}},
+		{"TAX", []uint8{0xAA}, []string{"R 8000", "R 8001"}},
+		{"PLA", []uint8{0x68}, []string{"R 8000", "R 8001", "R 01FD", "R 01FE"}},
+		{"JSR $9000", []uint8{0x20, 0x00, 0x90}, []string{"R 8000", "R 8001", "R 01FD", "W 01FD 80", "W 01FC 02", "R 8002"}},
+		{"BNE $8080", []uint8{0xD0, 0x7E}, []string{"R 8000", "R 8001", "R 8002"}},
+		{"BNE $7F82", []uint8{0xD0, 0x80}, []string{"R 8000", "R 8001", "R 8002", "R 8082"}},
+	}
+
+	for _, test := range tests {
+		memory := &RecordingBusMock{}
+		copy(memory.RAM[0x8000:], test.program)
+		memory.RAM[0x10] = 0x41
+		memory.WriteAddress(0xFFFC, 0x8000)
+
+		var cpu *CPU = &CPU{}
+		cpu.Bus = memory
+		cpu.PowerUp()
+		cpu.X = 1
+		memory.Accesses = nil
+
+		cpu.Step()
+		if fmt.Sprint(memory.Accesses) != fmt.Sprint(test.accesses) {
+			t.Errorf("%s: bus accesses %v instead of %v", test.name, memory.Accesses, test.accesses)
+		}
+	}
+}
+
+// CLI takes effect after the next instruction
+func TestCPUInterruptPolling(t *testing.T) {
+	memory := &BusMock{}
+	copy(memory.RAM[0x8000:], []uint8{0x58, 0xEA, 0xEA}) // CLI; NOP; NOP
+	memory.WriteAddress(0xFFFC, 0x8000)
+	memory.WriteAddress(0xFFFE, 0x9000)
+
+	var cpu *CPU = &CPU{}
+	cpu.Bus = memory
+	cpu.PowerUp()
+	for cpu.PC != 0x9000 {
+		cpu.InterruptIRQ()
+		cpu.Cycle()
+	}
+
+	if returnAddress := memory.ReadAddress(0x1FC); returnAddress != 0x8002 {
+		t.Errorf("The IRQ returns to %04X instead of 8002", returnAddress)
+	}
+}
+
+func TestOAMDMA(t *testing.T) {
+	nes := newTestNES(0, 32*1024, 8*1024)
+	for i := 0; i < 256; i++ {
+		nes.RAM[0x200+i] = uint8(i)
+	}
+	copy(nes.RAM[:], []uint8{0xA9, 0x02, 0x8D, 0x14, 0x40, 0xEA}) // LDA #$02; STA $4014; NOP
+	nes.CPU.PC = 0
+	nes.CPU.Step()
+	nes.CPU.Step()
+
+	cycles, instruction := nes.CPU.Step()
+	if instruction.Name != "NOP" || (cycles != 2+513 && cycles != 2+514) {
+		t.Error("Unexpected DMA duration: ", cycles, instruction.Name)
+	}
+	for i := 0; i < 256; i++ {
+		if nes.PPU.OAMData[i] != uint8(i) {
+			t.Fatal("Invalid OAM data at ", i)
+		}
+	}
+}
+
+// The DMA waits for the two writes of INC before halting the CPU
+func TestDMCDMAWriteCycles(t *testing.T) {
+	memory := &RecordingBusMock{}
+	copy(memory.RAM[0x8000:], []uint8{0xE6, 0x10}) // INC $10
+	memory.RAM[0x10] = 0x41
+	memory.WriteAddress(0xFFFC, 0x8000)
+
+	var cpu *CPU = &CPU{}
+	cpu.Bus = memory
+	cpu.PowerUp()
+	memory.Accesses = nil
+	for i := 0; i < 3; i++ {
+		cpu.Cycle()
+	}
+	cpu.startDMCDMA(&DMC{CurrentAddress: 0xC000, BytesRemaining: 1, BufferEmpty: true})
+	for i := 0; i < 7; i++ {
+		cpu.Cycle()
+	}
+
+	expected := []string{"R 8000", "R 8001", "R 0010", "W 0010 41", "W 0010 42", "R C000", "R 8002"}
+	if fmt.Sprint(memory.Accesses) != fmt.Sprint(expected) {
+		t.Errorf("Bus accesses %v instead of %v", memory.Accesses, expected)
+	}
+}
@@ -0,0 +1,569 @@
+package internals
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// https://wiki.nesdev.org/w/index.php?title=Family_Computer_Disk_System
+// https://wiki.nesdev.org/w/index.php?title=FDS_file_format
+// https://wiki.nesdev.org/w/index.php?title=FDS_disk_format
+
+const (
+	FDS_SIDE_SIZE = 65500 // Bytes of a side in a .fds file, without the gaps and CRCs
+	FDS_BIOS_SIZE = 0x2000
+
+	// The drive reads a byte every ~150 CPU cycles (96.4 kbit/s), after spinning up for a while
+	fdsByteDelay    = 150
+	fdsStartDelay   = 50000
+	fdsInsertDelay  = CPU_FREQUENCY // A swapped disk is inserted 1 second after the eject
+	fdsLeadingGap   = 28300 / 8
+	fdsBlockGap     = 976 / 8
+	fdsRawSideSize  = 0x14000 // Room for the gaps, the start marks and the CRCs of the blocks
+	fdsDiskInfoSize = 56
+)
+
+var ErrNoBIOS = errors.New("the FDS BIOS is needed to play disk images")
+
+type FDS struct {
+	baseMapper
+	Bus  *Bus
+	BIOS [FDS_BIOS_SIZE]uint8
+	RAM  [0x8000]uint8 // 0x6000 - 0xDFFF, the CHR-RAM is the one of the cartridge
+
+	// Sides as the drive sees them: with gaps, start marks and CRCs
+	Sides     [][]byte
+	Side      int  // Side in the drive, -1 when ejected
+	Modified  bool // A side was written since the image was loaded or saved
+	hasHeader bool
+
+	Registers FDSRegisters
+	Drive     FDSDrive
+
+	nextSide    int
+	insertDelay int
+}
+
+type FDSRegisters struct {
+	IRQReload    uint16
+	IRQCounter   uint16
+	IRQRepeat    bool
+	IRQEnabled   bool
+	DiskEnabled  bool // 0x4023 bit 0, the disk registers work only when set
+	SoundEnabled bool
+	WriteData    uint8
+	ReadData     uint8
+	External     uint8
+	Control      uint8 // 0x4025
+	TimerIRQ     bool
+	DiskIRQ      bool
+	TransferDone bool
+}
+
+// State of the drive motor and head
+type FDSDrive struct {
+	Position    int
+	Delay       int
+	EndOfHead   bool
+	Scanning    bool
+	GapEnded    bool
+	CRC         uint16
+	PreviousCRC bool // CRC control bit during the previous byte
+}
+
+func isFDS(data []byte) bool {
+	return (len(data) >= 4 && string(data[0:4]) == "FDS\x1A") ||
+		(len(data) >= 15 && data[0] == 0x01 && string(data[1:15]) == "*NINTENDO-HVC*")
+}
+
+// Reads a .fds file, with or without the 16 byte header
+func parseFDS(data []byte, bios []byte) (*FDS, error) {
+	if len(bios) != FDS_BIOS_SIZE {
+		return nil, ErrNoBIOS
+	}
+
+	fds := &FDS{Side: 0}
+	copy(fds.BIOS[:], bios)
+
+	sides := len(data) / FDS_SIDE_SIZE
+	if len(data) >= 4 && string(data[0:4]) == "FDS\x1A" {
+		if len(data) < 16 {
+			return nil, fmt.Errorf("%w: FDS header", ErrTruncated)
+		}
+		sides = int(data[4])
+		data = data[16:]
+		fds.hasHeader = true
+	}
+	if sides == 0 || len(data) < sides*FDS_SIDE_SIZE {
+		return nil, fmt.Errorf("%w: expected %d disk sides", ErrTruncated, sides)
+	}
+
+	for side := 0; side < sides; side++ {
+		raw, err := addGaps(data[side*FDS_SIDE_SIZE : (side+1)*FDS_SIDE_SIZE])
+		if err != nil {
+			return nil, fmt.Errorf("side %d: %w", side+1, err)
+		}
+		fds.Sides = append(fds.Sides, raw)
+	}
+	fds.Drive.EndOfHead = true
+	return fds, nil
+}
+
+// Length of the block starting at data[i], 0 at the end of the side. Block 4 holds the file
+// whose size is in the preceding block 3
+func fdsBlockLength(data []byte, i int) int {
+	switch data[i] {
+	case 1:
+		return fdsDiskInfoSize
+	case 2:
+		return 2
+	case 3:
+		return 16
+	case 4:
+		if i < 3 {
+			return 0
+		}
+		return 1 + (int(data[i-3]) | int(data[i-2])<<8)
+	default:
+		return 0
+	}
+}
+
+// Adds the gaps, start marks and CRCs between the blocks, as they are on the disk
+func addGaps(side []byte) ([]byte, error) {
+	if side[0] != 1 {
+		return nil, errors.New("missing disk info block")
+	}
+	raw := make([]byte, fdsLeadingGap, fdsRawSideSize)
+	for i := 0; i < len(side); {
+		length := fdsBlockLength(side, i)
+		if length == 0 {
+			break
+		}
+		if i+length > len(side) {
+			return nil, fmt.Errorf("%w: block %d", ErrTruncated, side[i])
+		}
+
+		block := append([]byte{0x80}, side[i:i+length]...)
+		crc := fdsCRC(append(block, 0, 0))
+		raw = append(raw, block...)
+		raw = append(raw, uint8(crc), uint8(crc>>8))
+		raw = append(raw, make([]byte, fdsBlockGap)...)
+		i += length
+	}
+	if len(raw) > fdsRawSideSize {
+		return nil, errors.New("disk side too large")
+	}
+	return raw[:fdsRawSideSize], nil
+}
+
+// Removes the gaps, start marks and CRCs, giving the side in the .fds format
+func removeGaps(raw []byte) []byte {
+	var side []byte
+	for i := 0; i < len(raw); {
+		for i < len(raw) && raw[i] == 0 {
+			i++
+		}
+		if i+1 >= len(raw) || raw[i] != 0x80 {
+			break
+		}
+		i++
+
+		// Block 4 needs the block 3 before it, which is at the end of side
+		length := fdsBlockLength(append(side[:len(side):len(side)], raw[i]), len(side))
+		if length == 0 || i+length > len(raw) || len(side)+length > FDS_SIDE_SIZE {
+			break
+		}
+		side = append(side, raw[i:i+length]...)
+		i += length + 2
+	}
+	padded := make([]byte, FDS_SIDE_SIZE)
+	copy(padded, side)
+	return padded
+}
+
+// CRC-16 of the blocks, over the start mark and the data
+func fdsCRC(data []byte) uint16 {
+	var crc uint16
+	for _, value := range data {
+		crc = fdsUpdateCRC(crc, value)
+	}
+	return crc
+}
+
+func fdsUpdateCRC(crc uint16, value uint8) uint16 {
+	for bit := uint8(0x01); bit != 0; bit <<= 1 {
+		carry := crc&1 != 0
+		crc >>= 1
+		if carry {
+			crc ^= 0x8408
+		}
+		if value&bit != 0 {
+			crc ^= 0x8000
+		}
+	}
+	return crc
+}
+
+// The disk image in the .fds format, with the modifications
+func (fds *FDS) Image() []byte {
+	var image []byte
+	if fds.hasHeader {
+		image = append([]byte("FDS\x1A"), uint8(len(fds.Sides)))
+		image = append(image, make([]byte, 11)...)
+	}
+	for _, raw := range fds.Sides {
+		image = append(image, removeGaps(raw)...)
+	}
+	return image
+}
+
+// Replaces the sides with the ones of a saved image. Ignored if the number of sides differs
+func (fds *FDS) loadImage(data []byte) error {
+	saved, err := parseFDS(data, fds.BIOS[:])
+	if err != nil {
+		return err
+	}
+	if len(saved.Sides) == len(fds.Sides) {
+		fds.Sides = saved.Sides
+	}
+	return nil
+}
+
+// The drive and the disk swap, with fixed size fields for the savestates
+type fdsDriveState struct {
+	Side        int32
+	NextSide    int32
+	InsertDelay int32
+	Modified    bool
+	Position    int32
+	Delay       int32
+	EndOfHead   bool
+	Scanning    bool
+	GapEnded    bool
+	CRC         uint16
+	PreviousCRC bool
+}
+
+// Saves the registers, the drive, the RAM and CHR-RAM, then every side of the disk
+func (fds *FDS) SaveState(writer io.Writer) error {
+	drive := fdsDriveState{
+		Side:        int32(fds.Side),
+		NextSide:    int32(fds.nextSide),
+		InsertDelay: int32(fds.insertDelay),
+		Modified:    fds.Modified,
+		Position:    int32(fds.Drive.Position),
+		Delay:       int32(fds.Drive.Delay),
+		EndOfHead:   fds.Drive.EndOfHead,
+		Scanning:    fds.Drive.Scanning,
+		GapEnded:    fds.Drive.GapEnded,
+		CRC:         fds.Drive.CRC,
+		PreviousCRC: fds.Drive.PreviousCRC,
+	}
+	for _, value := range []interface{}{&fds.Registers, &drive, &fds.RAM} {
+		if err := binary.Write(writer, binary.LittleEndian, value); err != nil {
+			return err
+		}
+	}
+	if err := fds.Bus.nes.Cartridge.saveMemory(writer); err != nil {
+		return err
+	}
+	for _, raw := range fds.Sides {
+		if _, err := writer.Write(raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The state has to come from the same disk image, with the same number of sides
+func (fds *FDS) LoadState(reader io.Reader) error {
+	var drive fdsDriveState
+	for _, value := range []interface{}{&fds.Registers, &drive, &fds.RAM} {
+		if err := binary.Read(reader, binary.LittleEndian, value); err != nil {
+			return err
+		}
+	}
+	if err := fds.Bus.nes.Cartridge.loadMemory(reader); err != nil {
+		return err
+	}
+	for _, raw := range fds.Sides {
+		if _, err := io.ReadFull(reader, raw); err != nil {
+			return err
+		}
+	}
+
+	fds.Side = int(drive.Side)
+	fds.nextSide = int(drive.NextSide)
+	fds.insertDelay = int(drive.InsertDelay)
+	fds.Modified = drive.Modified
+	fds.Drive = FDSDrive{
+		Position:    int(drive.Position),
+		Delay:       int(drive.Delay),
+		EndOfHead:   drive.EndOfHead,
+		Scanning:    drive.Scanning,
+		GapEnded:    drive.GapEnded,
+		CRC:         drive.CRC,
+		PreviousCRC: drive.PreviousCRC,
+	}
+	return nil
+}
+
+func (fds *FDS) diskInserted() bool {
+	return fds.Side >= 0 && fds.Side < len(fds.Sides)
+}
+
+func (fds *FDS) EjectDisk() {
+	fds.Side = -1
+	fds.insertDelay = 0
+}
+
+func (fds *FDS) InsertDisk(side int) {
+	fds.Side = side % len(fds.Sides)
+	fds.insertDelay = 0
+}
+
+// Ejects the disk, then inserts the next side a second later
+func (fds *FDS) SwitchSide() {
+	next := 0
+	if fds.diskInserted() {
+		next = (fds.Side + 1) % len(fds.Sides)
+	}
+	fds.EjectDisk()
+	fds.nextSide = next
+	fds.insertDelay = fdsInsertDelay
+}
+
+// https://wiki.nesdev.org/w/index.php?title=FDS_registers
+func (fds *FDS) ReadRegister(address uint16) uint8 {
+	registers := &fds.Registers
+	switch address {
+	case 0x4030: // Reading acknowledges the IRQs
+		var value uint8
+		if registers.TimerIRQ {
+			value |= 0x01
+		}
+		if registers.TransferDone {
+			value |= 0x02
+		}
+		if fds.Drive.EndOfHead {
+			value |= 0x40
+		}
+		if registers.DiskEnabled {
+			value |= 0x80
+		}
+		registers.TransferDone = false
+		registers.TimerIRQ = false
+		registers.DiskIRQ = false
+		return value
+	case 0x4031:
+		registers.TransferDone = false
+		registers.DiskIRQ = false
+		return registers.ReadData
+	case 0x4032:
+		value := uint8(0x40)
+		if !fds.diskInserted() {
+			value |= 0x01 | 0x04 // Not inserted, write protected
+		}
+		if !fds.diskInserted() || !fds.Drive.Scanning {
+			value |= 0x02
+		}
+		return value
+	case 0x4033: // Bit 7 set: the battery is good
+		return 0x80 | (registers.External & 0x7F)
+	default: // Write only registers and the sound, which is not emulated
+		return 0
+	}
+}
+
+func (fds *FDS) WriteRegister(address uint16, value uint8) {
+	registers := &fds.Registers
+	if !registers.DiskEnabled && address >= 0x4024 && address <= 0x4026 {
+		return
+	}
+
+	switch address {
+	case 0x4020:
+		registers.IRQReload = registers.IRQReload&0xFF00 | uint16(value)
+	case 0x4021:
+		registers.IRQReload = registers.IRQReload&0x00FF | uint16(value)<<8
+	case 0x4022:
+		registers.IRQRepeat = value&0x01 != 0
+		registers.IRQEnabled = value&0x02 != 0 && registers.DiskEnabled
+		if registers.IRQEnabled {
+			registers.IRQCounter = registers.IRQReload
+		} else {
+			registers.TimerIRQ = false
+		}
+	case 0x4023:
+		registers.DiskEnabled = value&0x01 != 0
+		registers.SoundEnabled = value&0x02 != 0
+		if !registers.DiskEnabled {
+			registers.IRQEnabled = false
+			registers.TimerIRQ = false
+			registers.DiskIRQ = false
+		}
+	case 0x4024:
+		registers.WriteData = value
+		registers.TransferDone = false
+		registers.DiskIRQ = false
+	case 0x4025:
+		registers.Control = value
+		registers.DiskIRQ = false
+	case 0x4026:
+		registers.External = value
+	}
+}
+
+// Called once for every CPU cycle
+func (fds *FDS) Cycle() {
+	fds.clockTimer()
+
+	if fds.insertDelay > 0 {
+		fds.insertDelay--
+		if fds.insertDelay == 0 {
+			fds.InsertDisk(fds.nextSide)
+		}
+	}
+
+	registers := &fds.Registers
+	drive := &fds.Drive
+	motorOn := registers.Control&0x01 != 0
+	resetTransfer := registers.Control&0x02 != 0
+	readMode := registers.Control&0x04 != 0
+	crcControl := registers.Control&0x10 != 0
+	diskReady := registers.Control&0x40 != 0
+	irqEnabled := registers.Control&0x80 != 0
+
+	if !fds.diskInserted() || !motorOn {
+		drive.EndOfHead = true
+		drive.Scanning = false
+		return
+	}
+	if resetTransfer && !drive.Scanning {
+		return
+	}
+	if drive.EndOfHead { // The head moves back to the start of the disk
+		drive.Delay = fdsStartDelay
+		drive.EndOfHead = false
+		drive.Position = 0
+		drive.GapEnded = false
+		return
+	}
+	if drive.Delay > 0 {
+		drive.Delay--
+		return
+	}
+
+	drive.Scanning = true
+	side := fds.Sides[fds.Side]
+	if readMode {
+		data := side[drive.Position]
+		if !drive.PreviousCRC {
+			drive.CRC = fdsUpdateCRC(drive.CRC, data)
+		}
+		needIRQ := irqEnabled
+		if !diskReady {
+			drive.GapEnded = false
+			drive.CRC = 0
+		} else if data != 0 && !drive.GapEnded {
+			// The start mark doesn't raise an IRQ
+			drive.GapEnded = true
+			needIRQ = false
+		}
+		if drive.GapEnded {
+			registers.TransferDone = true
+			registers.ReadData = data
+			registers.DiskIRQ = registers.DiskIRQ || needIRQ
+		}
+	} else {
+		var data uint8
+		if !crcControl {
+			registers.TransferDone = true
+			registers.DiskIRQ = registers.DiskIRQ || irqEnabled
+			data = registers.WriteData
+		}
+		if !diskReady {
+			data = 0
+		}
+		if !crcControl {
+			drive.CRC = fdsUpdateCRC(drive.CRC, data)
+		} else {
+			if !drive.PreviousCRC {
+				drive.CRC = fdsUpdateCRC(drive.CRC, 0)
+				drive.CRC = fdsUpdateCRC(drive.CRC, 0)
+			}
+			data = uint8(drive.CRC)
+			drive.CRC >>= 8
+		}
+		if side[drive.Position] != data {
+			side[drive.Position] = data
+			fds.Modified = true
+		}
+		drive.GapEnded = false
+	}
+	drive.PreviousCRC = crcControl
+
+	drive.Position++
+	if drive.Position >= len(side) {
+		drive.EndOfHead = true
+		registers.Control &^= 0x01 // The motor stops at the end of the disk
+	} else {
+		drive.Delay = fdsByteDelay
+	}
+}
+
+func (fds *FDS) clockTimer() {
+	registers := &fds.Registers
+	if !registers.IRQEnabled {
+		return
+	}
+	if registers.IRQCounter == 0 {
+		registers.TimerIRQ = true
+		registers.IRQCounter = registers.IRQReload
+		if !registers.IRQRepeat {
+			registers.IRQEnabled = false
+		}
+	} else {
+		registers.IRQCounter--
+	}
+}
+
+// The FDS is also the mapper of the cartridge: the RAM adapter holds the PRG-RAM and the BIOS
+func (fds *FDS) ReadPRG(address uint16) uint8 {
+	switch {
+	case address < 0x6000:
+		return 0
+	case address < 0xE000:
+		return fds.RAM[address-0x6000]
+	default:
+		return fds.BIOS[address-0xE000]
+	}
+}
+
+func (fds *FDS) WritePRG(address uint16, value uint8) {
+	if address >= 0x6000 && address < 0xE000 {
+		fds.RAM[address-0x6000] = value
+	}
+}
+
+func (fds *FDS) ReadCHR(address uint16) uint8 {
+	return fds.Bus.nes.Cartridge.CHR_ROM[address]
+}
+
+func (fds *FDS) WriteCHR(address uint16, value uint8) {
+	fds.Bus.nes.Cartridge.CHR_ROM[address] = value
+}
+
+func (fds *FDS) Mirroring() uint8 {
+	if fds.Registers.Control&0x08 != 0 {
+		return MIRRORING_HORIZONTAL
+	}
+	return MIRRORING_VERTICAL
+}
+
+func (fds *FDS) IRQ() bool {
+	return fds.Registers.TimerIRQ || fds.Registers.DiskIRQ
+}
@@ -0,0 +1,198 @@
+package internals
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// A disk side with a single 4 byte file
+func testDiskSide() []byte {
+	side := make([]byte, FDS_SIDE_SIZE)
+	info := append([]byte{0x01}, "*NINTENDO-HVC*"...)
+	copy(side, info)
+	pointer := fdsDiskInfoSize
+	copy(side[pointer:], []byte{0x02, 1})
+	pointer += 2
+	copy(side[pointer:], []byte{0x03, 0, 0, 'F', 'I', 'L', 'E', ' ', ' ', ' ', ' ', 0x00, 0x60, 4, 0, 0})
+	pointer += 16
+	copy(side[pointer:], []byte{0x04, 0xDE, 0xAD, 0xBE, 0xEF})
+	return side
+}
+
+func newTestFDS(t *testing.T) *NES {
+	image := append([]byte("FDS\x1A\x01"), make([]byte, 11)...)
+	image = append(image, testDiskSide()...)
+	nes := NewNES()
+	nes.FDSBIOS = make([]byte, FDS_BIOS_SIZE)
+	if err := nes.LoadROM(bytes.NewReader(image)); err != nil {
+		t.Fatal(err)
+	}
+	return nes
+}
+
+func TestFDSImage(t *testing.T) {
+	nes := newTestFDS(t)
+	if len(nes.FDS.Sides) != 1 {
+		t.Fatal("Wrong number of sides: ", len(nes.FDS.Sides))
+	}
+	if image := nes.FDS.Image(); !bytes.Equal(image[16:], testDiskSide()) || string(image[:5]) != "FDS\x1A\x01" {
+		t.Error("The image changed after adding and removing the gaps")
+	}
+
+	// The CRC of a block followed by its CRC is 0
+	raw := nes.FDS.Sides[0]
+	start := bytes.IndexByte(raw, 0x80)
+	if crc := fdsCRC(raw[start : start+1+fdsDiskInfoSize+2]); crc != 0 {
+		t.Errorf("Wrong block CRC: %04X", crc)
+	}
+
+	if err := NewNES().LoadROM(bytes.NewReader(testDiskSide())); err != ErrNoBIOS {
+		t.Error("Missing BIOS not reported: ", err)
+	}
+}
+
+func TestFDSDrive(t *testing.T) {
+	nes := newTestFDS(t)
+	nes.Bus.Write(0x4023, 0x01)
+	nes.Bus.Write(0x4025, 0x80|0x40|0x04|0x01) // IRQ, start of the data, read mode, motor on
+
+	expected := append(append([]byte{0x01}, "*NINTENDO-HVC*"...), 0, 0)
+	for _, value := range expected[:15] {
+		cycles := 0
+		for !nes.Cartridge.Mapper.IRQ() && cycles < 1000000 {
+			nes.FDS.Cycle()
+			cycles++
+		}
+		if !nes.Cartridge.Mapper.IRQ() {
+			t.Fatal("No disk IRQ")
+		}
+		if data := nes.Bus.Read(0x4031); data != value {
+			t.Fatalf("Read %02X, expected %02X", data, value)
+		}
+		if nes.Cartridge.Mapper.IRQ() {
+			t.Fatal("Disk IRQ not acknowledged")
+		}
+	}
+	if nes.Bus.Read(0x4032)&0x03 != 0 {
+		t.Error("The disk should be inserted and ready")
+	}
+
+	nes.FDS.SwitchSide()
+	if nes.Bus.Read(0x4032)&0x01 == 0 {
+		t.Error("The disk should be ejected")
+	}
+	for i := 0; i < fdsInsertDelay; i++ {
+		nes.FDS.Cycle()
+	}
+	if nes.FDS.Side != 0 {
+		t.Error("The disk was not inserted again")
+	}
+}
+
+func TestFDSTimerIRQ(t *testing.T) {
+	nes := newTestFDS(t)
+	nes.Bus.Write(0x4023, 0x01)
+	nes.Bus.Write(0x4020, 10)
+	nes.Bus.Write(0x4021, 0)
+	nes.Bus.Write(0x4022, 0x02)
+	for i := 0; i < 10; i++ {
+		nes.FDS.Cycle()
+	}
+	if nes.Cartridge.Mapper.IRQ() {
+		t.Error("Early timer IRQ")
+	}
+	nes.FDS.Cycle()
+	if !nes.Cartridge.Mapper.IRQ() {
+		t.Error("No timer IRQ")
+	}
+	if nes.Bus.Read(0x4030)&0x01 == 0 || nes.Cartridge.Mapper.IRQ() {
+		t.Error("Timer IRQ not reported and acknowledged")
+	}
+}
+
+func TestFDSWriteBack(t *testing.T) {
+	storage := NewMemoryStorage()
+	nes := newTestFDS(t)
+	nes.Storage = storage
+	nes.SaveName = "disk.sav"
+
+	writer := newTestFDS(t)
+	writer.Bus.Write(0x4023, 0x01)
+	writer.Bus.Write(0x4024, 0x55)
+	writer.Bus.Write(0x4025, 0x40|0x01) // Write mode
+	for i := 0; i < fdsStartDelay+fdsByteDelay*10; i++ {
+		writer.FDS.Cycle()
+	}
+	if !writer.FDS.Modified || writer.FDS.Sides[0][5] != 0x55 {
+		t.Fatal("The disk was not written")
+	}
+
+	file := bytes.Index(nes.FDS.Sides[0], []byte{0xDE, 0xAD, 0xBE, 0xEF})
+	nes.FDS.Sides[0][file] = 0x42
+	nes.FDS.Modified = true
+
+	if err := nes.SaveBattery(); err != nil {
+		t.Fatal(err)
+	}
+	if nes.FDS.Modified {
+		t.Error("Modified not cleared")
+	}
+
+	other := newTestFDS(t)
+	other.Storage = storage
+	other.SaveName = "disk.sav"
+	if err := other.LoadBattery(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(other.FDS.Image(), nes.FDS.Image()) {
+		t.Error("The disk image was not restored")
+	}
+}
+
+func TestFDSTruncatedSave(t *testing.T) {
+	for _, data := range [][]byte{{}, []byte("FDS"), []byte("FDS\x1A\x01")} {
+		storage := NewMemoryStorage()
+		storage.Files["disk.sav"] = data
+		nes := newTestFDS(t)
+		nes.Storage = storage
+		nes.SaveName = "disk.sav"
+		if err := nes.LoadBattery(); !errors.Is(err, ErrTruncated) {
+			t.Errorf("Save of %d bytes: got %v, expected %v", len(data), err, ErrTruncated)
+		}
+	}
+}
+
+func TestFDSSaveState(t *testing.T) {
+	nes := newTestFDS(t)
+	nes.Bus.Write(0x4023, 0x01)
+	nes.Bus.Write(0x4020, 0x34)
+	nes.Bus.Write(0x4022, 0x02)
+	nes.Bus.Write(0x6000, 0x11)
+	nes.Cartridge.Write(0x0010, 0x22)
+	nes.FDS.Sides[0][5] = 0x33
+	nes.FDS.Drive.Position = 1234
+
+	var state bytes.Buffer
+	if err := nes.FDS.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+	nes.Bus.Write(0x4022, 0x00)
+	nes.Bus.Write(0x6000, 0x00)
+	nes.Cartridge.Write(0x0010, 0x00)
+	nes.FDS.Sides[0][5] = 0x00
+	nes.FDS.Drive.Position = 0
+	if err := nes.FDS.LoadState(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	if !nes.FDS.Registers.IRQEnabled || nes.FDS.Registers.IRQCounter != 0x34 {
+		t.Errorf("Timer IRQ not restored: %+v", nes.FDS.Registers)
+	}
+	if nes.Bus.Read(0x6000) != 0x11 || nes.Cartridge.Read(0x0010) != 0x22 {
+		t.Error("RAM or CHR-RAM not restored")
+	}
+	if nes.FDS.Sides[0][5] != 0x33 || nes.FDS.Drive.Position != 1234 {
+		t.Error("Disk not restored")
+	}
+}
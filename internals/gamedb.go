@@ -0,0 +1,255 @@
+package internals
+
+import (
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Embedded game database, used to correct the headers of bad dumps
+// https://wiki.nesdev.org/w/index.php?title=NES_2.0_XML_Database
+//
+//go:embed gamedb.xml
+var gameDBData []byte
+
+// A game of the database
+type GameInfo struct {
+	Title  string
+	Board  string // Empty when unknown
+	Region uint8  // TIMING_*
+	CRC32  uint32 // Of the PRG-ROM followed by the CHR-ROM
+	SHA1   [sha1.Size]byte
+
+	// The header fields given by the database, see Fields. The ROM sizes are the ones of the dump
+	Header Header
+	Fields uint // GAME_* flags
+	// The entry also fixes NES 2.0 headers, which are otherwise trusted
+	Correction bool
+}
+
+// The groups of header fields that a game of the database can give
+const (
+	GAME_MAPPER    = 1 << iota // Mapper and submapper
+	GAME_MIRRORING             // Mirroring and four-screen VRAM
+	GAME_BATTERY
+	GAME_PRG_RAM
+	GAME_PRG_NVRAM
+	GAME_CHR_RAM
+	GAME_CHR_NVRAM
+	GAME_REGION
+	GAME_CONSOLE // Console type, VS System and PlayChoice-10
+	GAME_EXPANSION
+)
+
+type GameDB struct {
+	byCRC32 map[uint32]*GameInfo
+	bySHA1  map[[sha1.Size]byte]*GameInfo
+}
+
+type gameDBSize struct {
+	Size uint `xml:"size,attr"`
+}
+
+// The elements and attributes missing from an entry are nil
+type gameDBGame struct {
+	Comment    string `xml:",comment"`
+	Correction bool   `xml:"correction,attr"`
+	ROM        struct {
+		Size  uint   `xml:"size,attr"`
+		CRC32 string `xml:"crc32,attr"`
+		SHA1  string `xml:"sha1,attr"`
+	} `xml:"rom"`
+	PRGRAM   *gameDBSize `xml:"prgram"`
+	PRGNVRAM *gameDBSize `xml:"prgnvram"`
+	CHRRAM   *gameDBSize `xml:"chrram"`
+	CHRNVRAM *gameDBSize `xml:"chrnvram"`
+	PCB      struct {
+		Mapper    *uint  `xml:"mapper,attr"`
+		Submapper uint   `xml:"submapper,attr"`
+		Mirroring string `xml:"mirroring,attr"` // H, V, 4, or 1 when the mapper decides
+		Battery   *uint8 `xml:"battery,attr"`
+		Name      string `xml:"name,attr"`
+	} `xml:"pcb"`
+	Console struct {
+		Type   *uint8 `xml:"type,attr"`
+		Region *uint8 `xml:"region,attr"`
+	} `xml:"console"`
+	Expansion struct {
+		Type *uint8 `xml:"type,attr"`
+	} `xml:"expansion"`
+}
+
+// Reads a database in the NES 2.0 XML format
+func ParseGameDB(reader io.Reader) (*GameDB, error) {
+	var file struct {
+		Games []gameDBGame `xml:"game"`
+	}
+	if err := xml.NewDecoder(reader).Decode(&file); err != nil {
+		return nil, err
+	}
+
+	db := &GameDB{byCRC32: map[uint32]*GameInfo{}, bySHA1: map[[sha1.Size]byte]*GameInfo{}}
+	for _, game := range file.Games {
+		info, err := game.info()
+		if err != nil {
+			return nil, err
+		}
+
+		if crc, err := strconv.ParseUint(game.ROM.CRC32, 16, 32); err == nil {
+			info.CRC32 = uint32(crc)
+			db.byCRC32[info.CRC32] = info
+		}
+		if sum, err := hex.DecodeString(game.ROM.SHA1); err == nil && len(sum) == sha1.Size {
+			copy(info.SHA1[:], sum)
+			db.bySHA1[info.SHA1] = info
+		}
+	}
+	return db, nil
+}
+
+func (game *gameDBGame) info() (*GameInfo, error) {
+	info := &GameInfo{Title: gameTitle(game.Comment), Board: game.PCB.Name, Correction: game.Correction}
+	header := &info.Header
+
+	if game.PCB.Mapper != nil {
+		header.Mapper = *game.PCB.Mapper
+		header.Submapper = game.PCB.Submapper
+		info.Fields |= GAME_MAPPER
+	}
+	if game.PCB.Mirroring != "" {
+		header.Mirroring = game.PCB.Mirroring == "V"
+		header.IgnoreMorriring = game.PCB.Mirroring == "4"
+		info.Fields |= GAME_MIRRORING
+	}
+	if game.PCB.Battery != nil {
+		header.PersistentRAM = *game.PCB.Battery != 0
+		info.Fields |= GAME_BATTERY
+	}
+
+	sizes := []struct {
+		element *gameDBSize
+		size    *uint
+		field   uint
+	}{
+		{game.PRGRAM, &header.PRG_RAM_size, GAME_PRG_RAM},
+		{game.PRGNVRAM, &header.PRG_NVRAM_size, GAME_PRG_NVRAM},
+		{game.CHRRAM, &header.CHR_RAM_size, GAME_CHR_RAM},
+		{game.CHRNVRAM, &header.CHR_NVRAM_size, GAME_CHR_NVRAM},
+	}
+	for _, size := range sizes {
+		if size.element != nil {
+			*size.size = size.element.Size
+			info.Fields |= size.field
+		}
+	}
+
+	if region := game.Console.Region; region != nil {
+		if *region > TIMING_DENDY {
+			return nil, fmt.Errorf("%s: invalid region %d", info.Title, *region)
+		}
+		info.Region = *region
+		header.Timing = *region
+		info.Fields |= GAME_REGION
+	}
+	// The console type is 0 - 2, or one of the extended types of the header
+	if consoleType := game.Console.Type; consoleType != nil {
+		if *consoleType > 0x0F {
+			return nil, fmt.Errorf("%s: invalid console type %d", info.Title, *consoleType)
+		}
+		header.ConsoleType = *consoleType
+		if *consoleType >= CONSOLE_EXTENDED {
+			header.ConsoleType = CONSOLE_EXTENDED
+			header.ExtendedConsole = *consoleType
+		}
+		header.VSUnisystem = *consoleType == CONSOLE_VS_SYSTEM
+		header.PlayChoice = *consoleType == CONSOLE_PLAYCHOICE
+		info.Fields |= GAME_CONSOLE
+	}
+	if game.Expansion.Type != nil {
+		header.ExpansionDevice = *game.Expansion.Type
+		info.Fields |= GAME_EXPANSION
+	}
+	return info, nil
+}
+
+// The comment holds the file name of the dump, e.g. "USA\Game (USA).nes"
+func gameTitle(comment string) string {
+	title := strings.TrimSpace(comment)
+	title = title[strings.LastIndexAny(title, `\/`)+1:]
+	return strings.TrimSuffix(title, path.Ext(title))
+}
+
+// Finds a game by the PRG-ROM followed by the CHR-ROM. The SHA-1 is tried first. Returns nil when
+// the game is unknown
+func (db *GameDB) Lookup(rom []byte) *GameInfo {
+	if info, ok := db.bySHA1[sha1.Sum(rom)]; ok {
+		return info
+	}
+	return db.byCRC32[crc32.ChecksumIEEE(rom)]
+}
+
+var (
+	embeddedGameDB     *GameDB
+	embeddedGameDBOnce sync.Once
+)
+
+// The database embedded in the emulator, parsed on first use
+func EmbeddedGameDB() *GameDB {
+	embeddedGameDBOnce.Do(func() {
+		db, err := ParseGameDB(bytes.NewReader(gameDBData))
+		if err != nil {
+			panic("Invalid embedded game database: " + err.Error())
+		}
+		embeddedGameDB = db
+	})
+	return embeddedGameDB
+}
+
+// Replaces the header fields given by the database. NES 2.0 headers are kept, unless the entry is
+// marked as a correction
+func (header *Header) applyGameInfo(info *GameInfo) {
+	if header.NES2 && !info.Correction {
+		return
+	}
+	known := &info.Header
+	if info.Fields&GAME_MAPPER != 0 {
+		header.Mapper, header.Submapper = known.Mapper, known.Submapper
+	}
+	if info.Fields&GAME_MIRRORING != 0 {
+		header.Mirroring, header.IgnoreMorriring = known.Mirroring, known.IgnoreMorriring
+	}
+	if info.Fields&GAME_BATTERY != 0 {
+		header.PersistentRAM = known.PersistentRAM
+	}
+	if info.Fields&GAME_PRG_RAM != 0 {
+		header.PRG_RAM_size = known.PRG_RAM_size
+	}
+	if info.Fields&GAME_PRG_NVRAM != 0 {
+		header.PRG_NVRAM_size = known.PRG_NVRAM_size
+	}
+	if info.Fields&GAME_CHR_RAM != 0 {
+		header.CHR_RAM_size = known.CHR_RAM_size
+	}
+	if info.Fields&GAME_CHR_NVRAM != 0 {
+		header.CHR_NVRAM_size = known.CHR_NVRAM_size
+	}
+	if info.Fields&GAME_REGION != 0 {
+		header.Timing = known.Timing
+	}
+	if info.Fields&GAME_CONSOLE != 0 {
+		header.ConsoleType, header.ExtendedConsole = known.ConsoleType, known.ExtendedConsole
+		header.VSUnisystem, header.PlayChoice = known.VSUnisystem, known.PlayChoice
+	}
+	if info.Fields&GAME_EXPANSION != 0 {
+		header.ExpansionDevice = known.ExpansionDevice
+	}
+}
@@ -0,0 +1,100 @@
+package internals
+
+import (
+	"strings"
+	"testing"
+)
+
+const testGameDB = `<?xml version="1.0" encoding="UTF-8"?>
+<nes20db>
+	<game>
+		<!-- USA\Test Game (USA).nes -->
+		<rom size="4" crc32="B63CFBCD" sha1="0000000000000000000000000000000000000000"/>
+		<prgnvram size="8192"/>
+		<pcb mapper="1" submapper="0" mirroring="V" battery="1" name="NES-SNROM"/>
+		<console type="0" region="1"/>
+	</game>
+</nes20db>`
+
+func TestGameDB(t *testing.T) {
+	db, err := ParseGameDB(strings.NewReader(testGameDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Matched by the CRC32 of 1, 2, 3, 4
+	info := db.Lookup([]byte{1, 2, 3, 4})
+	if info == nil {
+		t.Fatal("Game not found")
+	}
+	if info.Title != "Test Game (USA)" || info.Board != "NES-SNROM" || info.Region != TIMING_PAL {
+		t.Errorf("Wrong game info: %+v", info)
+	}
+	if db.Lookup([]byte{1, 2, 3}) != nil {
+		t.Error("Unknown game found")
+	}
+
+	header := Header{PRG_ROM_size: 0x4000, CHR_ROM_size: 0x2000, Mapper: 4, Trainer: true}
+	header.applyGameInfo(info)
+	expected := Header{PRG_ROM_size: 0x4000, CHR_ROM_size: 0x2000, Mapper: 1, Trainer: true, Mirroring: true,
+		PersistentRAM: true, PRG_NVRAM_size: 8192, Timing: TIMING_PAL}
+	if header != expected {
+		t.Errorf("Wrong corrected header:\n%+v\nexpected:\n%+v", header, expected)
+	}
+
+	nes := NewNES()
+	if err := nes.LoadFile("tests/nestest.nes"); err != nil {
+		t.Fatal(err)
+	}
+	if nes.Cartridge.Game == nil || nes.Cartridge.Game.Title != "nestest" {
+		t.Error("nestest not found in the embedded database")
+	}
+}
+
+func TestGameDBConsoleType(t *testing.T) {
+	game := func(consoleType string) string {
+		return `<nes20db><game><!-- Test.nes --><rom crc32="B63CFBCD"/><console type="` + consoleType + `"/></game></nes20db>`
+	}
+
+	db, err := ParseGameDB(strings.NewReader(game("5")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := db.Lookup([]byte{1, 2, 3, 4}).Header
+	if header.ConsoleType != CONSOLE_EXTENDED || header.ExtendedConsole != 5 {
+		t.Errorf("Extended console type not kept: %+v", header)
+	}
+
+	if _, err := ParseGameDB(strings.NewReader(game("16"))); err == nil {
+		t.Error("Invalid console type accepted")
+	}
+}
+
+func TestGameDBPartialEntry(t *testing.T) {
+	const partial = `<nes20db>
+	<game><!-- Partial.nes --><rom crc32="B63CFBCD"/><pcb mapper="2"/></game>
+	<game correction="1"><!-- Correction.nes --><rom crc32="55BC801D"/><pcb mapper="3"/></game>
+</nes20db>`
+	db, err := ParseGameDB(strings.NewReader(partial))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the mapper is given, the battery and the RAM sizes of the header are kept
+	header := Header{Mapper: 4, PersistentRAM: true, PRG_NVRAM_size: 0x2000, Timing: TIMING_PAL}
+	header.applyGameInfo(db.Lookup([]byte{1, 2, 3, 4}))
+	expected := Header{Mapper: 2, PersistentRAM: true, PRG_NVRAM_size: 0x2000, Timing: TIMING_PAL}
+	if header != expected {
+		t.Errorf("Wrong corrected header:\n%+v\nexpected:\n%+v", header, expected)
+	}
+
+	nes2 := Header{NES2: true, Mapper: 4}
+	nes2.applyGameInfo(db.Lookup([]byte{1, 2, 3, 4}))
+	if nes2.Mapper != 4 {
+		t.Error("NES 2.0 header overridden")
+	}
+	nes2.applyGameInfo(db.Lookup([]byte{1, 2, 3}))
+	if nes2.Mapper != 3 {
+		t.Error("NES 2.0 header not corrected")
+	}
+}
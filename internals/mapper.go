@@ -0,0 +1,109 @@
+package internals
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// https://wiki.nesdev.org/w/index.php?title=Mapper
+
+const (
+	_ = iota
+	MIRRORING_HORIZONTAL
+	MIRRORING_VERTICAL
+	MIRRORING_SINGLE_LOWER // Single-screen A: every name table uses the first 1 KB
+	MIRRORING_SINGLE_UPPER // Single-screen B: every name table uses the second 1 KB
+	MIRRORING_FOUR_SCREEN  // The cartridge VRAM gives every name table its own 1 KB
+)
+
+// The 1 KB of VRAM used by each of the four name tables ($2000, $2400, $2800, $2C00), for each mirroring
+var NAMETABLE_LAYOUTS = [...][4]uint8{
+	MIRRORING_HORIZONTAL:   {0, 0, 1, 1},
+	MIRRORING_VERTICAL:     {0, 1, 0, 1},
+	MIRRORING_SINGLE_LOWER: {0, 0, 0, 0},
+	MIRRORING_SINGLE_UPPER: {1, 1, 1, 1},
+	MIRRORING_FOUR_SCREEN:  {0, 1, 2, 3},
+}
+
+// The board of the cartridge. Decides what the CPU and the PPU see at the cartridge addresses
+type Mapper interface {
+	ReadPRG(address uint16) uint8 // CPU bus, 0x4020 - 0xFFFF
+	WritePRG(address uint16, value uint8)
+	ReadCHR(address uint16) uint8 // PPU bus, 0x0000 - 0x1FFF
+	WriteCHR(address uint16, value uint8)
+
+	Mirroring() uint8
+	IRQ() bool // State of the IRQ line, true while asserted
+
+	Scanline()                 // Called at the end of every rendered scanline
+	PPUAddress(address uint16) // Called for every address put on the PPU bus, for mappers watching A12
+
+	// The registers of the board, followed by its PRG-RAM and CHR-RAM
+	SaveState(writer io.Writer) error
+	LoadState(reader io.Reader) error
+}
+
+// Implemented by the mappers that choose the VRAM of every name table, instead of using one of
+// the mirroring layouts. Mirroring() is then ignored
+type NametableMapper interface {
+	Nametable(quadrant uint8) uint8 // 0 - 3, the last two are the cartridge VRAM
+}
+
+type mapperEntry struct {
+	prgBankSize uint
+	constructor func(*Cartridge) Mapper
+}
+
+var mappers = map[uint]mapperEntry{}
+
+// Makes a mapper available to the cartridges using the given iNES mapper number. The PRG-ROM of
+// these cartridges has to be made of whole banks of prgBankSize bytes
+func RegisterMapper(number uint, prgBankSize uint, constructor func(*Cartridge) Mapper) {
+	mappers[number] = mapperEntry{prgBankSize, constructor}
+}
+
+// Returned when no mapper is registered for the number in the header
+type ErrUnsupportedMapper struct {
+	Mapper uint
+}
+
+func (err ErrUnsupportedMapper) Error() string {
+	return "unsupported mapper: " + strconv.Itoa(int(err.Mapper))
+}
+
+// Returned when the PRG-ROM is not made of whole banks of the mapper
+var ErrPRGROMSize = errors.New("PRG-ROM size is not a multiple of the bank size")
+
+func newMapper(cartridge *Cartridge) (Mapper, error) {
+	entry, ok := mappers[cartridge.Header.Mapper]
+	if !ok {
+		return nil, ErrUnsupportedMapper{cartridge.Header.Mapper}
+	}
+	if size := uint(len(cartridge.PRG_ROM)); size%entry.prgBankSize != 0 {
+		return nil, fmt.Errorf("%w: %d bytes in banks of %d bytes", ErrPRGROMSize, size, entry.prgBankSize)
+	}
+	return entry.constructor(cartridge), nil
+}
+
+func headerMirroring(header *Header) uint8 {
+	if header.IgnoreMorriring {
+		return MIRRORING_FOUR_SCREEN
+	}
+	if header.Mirroring {
+		return MIRRORING_VERTICAL
+	}
+	return MIRRORING_HORIZONTAL
+}
+
+// Default implementations for the mappers that don't use some of the features
+type baseMapper struct{}
+
+func (mapper *baseMapper) IRQ() bool {
+	return false
+}
+
+func (mapper *baseMapper) Scanline() {}
+
+func (mapper *baseMapper) PPUAddress(address uint16) {}
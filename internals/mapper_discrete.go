@@ -0,0 +1,137 @@
+package internals
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Boards made of discrete logic chips, with a single register written anywhere in 0x8000 - 0xFFFF
+// https://wiki.nesdev.org/w/index.php?title=UxROM
+// https://wiki.nesdev.org/w/index.php?title=CNROM
+// https://wiki.nesdev.org/w/index.php?title=AxROM
+// https://wiki.nesdev.org/w/index.php?title=GxROM
+// https://wiki.nesdev.org/w/index.php?title=Color_Dreams
+type DiscreteMapper struct {
+	baseMapper
+	cartridge *Cartridge
+	number    uint
+	Registers DiscreteRegisters
+
+	// The ROM drives the data bus during the register write, the register gets the AND of both values
+	// https://wiki.nesdev.org/w/index.php?title=Bus_conflict
+	BusConflicts bool
+}
+
+type DiscreteRegisters struct {
+	PRGBank   uint8 // 16 KB for UxROM, 32 KB for the others
+	CHRBank   uint8 // 8 KB
+	Mirroring uint8 // AxROM only
+}
+
+func init() {
+	// UxROM switches 16 KB banks and CNROM has NROM's PRG-ROM, the others switch 32 KB
+	for number, busConflicts := range map[uint]bool{2: true, 3: true, 7: false, 11: true, 66: true} {
+		number, busConflicts := number, busConflicts
+		prgBankSize := uint(0x8000)
+		if number == 2 || number == 3 {
+			prgBankSize = 0x4000
+		}
+		RegisterMapper(number, prgBankSize, func(cartridge *Cartridge) Mapper {
+			mapper := &DiscreteMapper{cartridge: cartridge, number: number, BusConflicts: busConflicts}
+			mapper.Registers.Mirroring = MIRRORING_SINGLE_LOWER
+			return mapper
+		})
+	}
+}
+
+func (mapper *DiscreteMapper) ReadPRG(address uint16) uint8 {
+	switch {
+	case address < 0x6000:
+		return 0
+	case address < 0x8000:
+		return mapper.cartridge.RAM[address-0x6000]
+	}
+
+	prg := mapper.cartridge.PRG_ROM
+	var offset int
+	if mapper.number == 2 {
+		bank := int(mapper.Registers.PRGBank)
+		if address >= 0xC000 {
+			bank = (len(prg) - 1) / 0x4000
+		}
+		offset = bank*0x4000 + int(address&0x3FFF)
+	} else {
+		offset = int(mapper.Registers.PRGBank)*0x8000 + int(address-0x8000)
+	}
+	return prg[offset%len(prg)]
+}
+
+func (mapper *DiscreteMapper) WritePRG(address uint16, value uint8) {
+	switch {
+	case address < 0x6000:
+		return
+	case address < 0x8000:
+		mapper.cartridge.RAM[address-0x6000] = value
+		return
+	}
+
+	if mapper.BusConflicts {
+		value &= mapper.ReadPRG(address)
+	}
+
+	registers := &mapper.Registers
+	switch mapper.number {
+	case 2:
+		registers.PRGBank = value
+	case 3:
+		registers.CHRBank = value
+	case 7:
+		registers.PRGBank = value & 0x07
+		if value&0x10 == 0 {
+			registers.Mirroring = MIRRORING_SINGLE_LOWER
+		} else {
+			registers.Mirroring = MIRRORING_SINGLE_UPPER
+		}
+	case 11:
+		registers.PRGBank = value & 0x03
+		registers.CHRBank = value >> 4
+	case 66:
+		registers.PRGBank = (value >> 4) & 0x03
+		registers.CHRBank = value & 0x03
+	}
+}
+
+func (mapper *DiscreteMapper) chrOffset(address uint16) int {
+	return (int(mapper.Registers.CHRBank)*0x2000 + int(address)) % len(mapper.cartridge.CHR_ROM)
+}
+
+func (mapper *DiscreteMapper) ReadCHR(address uint16) uint8 {
+	return mapper.cartridge.CHR_ROM[mapper.chrOffset(address)]
+}
+
+func (mapper *DiscreteMapper) WriteCHR(address uint16, value uint8) {
+	if mapper.cartridge.CHR_RAM {
+		mapper.cartridge.CHR_ROM[mapper.chrOffset(address)] = value
+	}
+}
+
+func (mapper *DiscreteMapper) Mirroring() uint8 {
+	if mapper.number == 7 {
+		return mapper.Registers.Mirroring
+	}
+	return headerMirroring(&mapper.cartridge.Header)
+}
+
+func (mapper *DiscreteMapper) SaveState(writer io.Writer) error {
+	if err := binary.Write(writer, binary.LittleEndian, &mapper.Registers); err != nil {
+		return err
+	}
+	return mapper.cartridge.saveMemory(writer)
+}
+
+func (mapper *DiscreteMapper) LoadState(reader io.Reader) error {
+	if err := binary.Read(reader, binary.LittleEndian, &mapper.Registers); err != nil {
+		return err
+	}
+	return mapper.cartridge.loadMemory(reader)
+}
@@ -0,0 +1,181 @@
+package internals
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// https://wiki.nesdev.org/w/index.php?title=MMC1
+type MMC1 struct {
+	baseMapper
+	cartridge *Cartridge
+	Registers MMC1Registers
+}
+
+type MMC1Registers struct {
+	Shift      uint8 // Serial load register
+	ShiftCount uint8
+	Control    uint8 // CPPMM: CHR mode, PRG mode, mirroring
+	CHRBank0   uint8
+	CHRBank1   uint8
+	PRGBank    uint8 // RPPPP: PRG-RAM disable, PRG bank
+
+	LastWriteCycle uint64
+}
+
+func init() {
+	RegisterMapper(1, 0x4000, func(cartridge *Cartridge) Mapper {
+		mapper := &MMC1{cartridge: cartridge}
+		mapper.Registers.Control = 0x0C // The last bank is fixed at 0xC000
+		return mapper
+	})
+}
+
+func (mapper *MMC1) ReadPRG(address uint16) uint8 {
+	switch {
+	case address < 0x6000:
+		return 0
+	case address < 0x8000:
+		if mapper.Registers.PRGBank&0x10 != 0 { // PRG-RAM disabled
+			return 0
+		}
+		return mapper.cartridge.RAM[address-0x6000]
+	default:
+		return mapper.cartridge.PRG_ROM[mapper.prgOffset(address)]
+	}
+}
+
+func (mapper *MMC1) prgOffset(address uint16) int {
+	registers := &mapper.Registers
+	count := len(mapper.cartridge.PRG_ROM) / 0x4000
+	bank := int(registers.PRGBank & 0x0F)
+	last := (count - 1) & 0x0F
+
+	var index int
+	switch (registers.Control >> 2) & 0x03 {
+	case 0, 1: // 32 KB
+		index = (bank &^ 1) | int((address-0x8000)/0x4000)
+	case 2: // First bank fixed at 0x8000
+		if address < 0xC000 {
+			index = 0
+		} else {
+			index = bank
+		}
+	case 3: // Last bank fixed at 0xC000
+		if address < 0xC000 {
+			index = bank
+		} else {
+			index = last
+		}
+	}
+
+	// SUROM: 512 KB of PRG-ROM, the bit 4 of the CHR bank selects the 256 KB half
+	if count > 16 {
+		index |= int(registers.CHRBank0 & 0x10)
+	}
+
+	return (index*0x4000 + int(address&0x3FFF)) % len(mapper.cartridge.PRG_ROM)
+}
+
+func (mapper *MMC1) WritePRG(address uint16, value uint8) {
+	switch {
+	case address < 0x6000:
+	case address < 0x8000:
+		if mapper.Registers.PRGBank&0x10 == 0 {
+			mapper.cartridge.RAM[address-0x6000] = value
+		}
+	default:
+		mapper.writeRegister(address, value)
+	}
+}
+
+func (mapper *MMC1) writeRegister(address uint16, value uint8) {
+	registers := &mapper.Registers
+
+	// Writes on consecutive cycles (the read-modify-write instructions) only see the first one
+	cycle := mapper.cartridge.Bus.nes.CPU.CycleCount
+	consecutive := registers.LastWriteCycle != 0 && cycle-registers.LastWriteCycle <= 1
+	registers.LastWriteCycle = cycle
+	if consecutive {
+		return
+	}
+
+	if value&0x80 != 0 {
+		registers.Shift = 0
+		registers.ShiftCount = 0
+		registers.Control |= 0x0C
+		return
+	}
+
+	registers.Shift |= (value & 0x01) << registers.ShiftCount
+	registers.ShiftCount++
+	if registers.ShiftCount < 5 {
+		return
+	}
+
+	switch {
+	case address < 0xA000:
+		registers.Control = registers.Shift
+	case address < 0xC000:
+		registers.CHRBank0 = registers.Shift
+	case address < 0xE000:
+		registers.CHRBank1 = registers.Shift
+	default:
+		registers.PRGBank = registers.Shift
+	}
+	registers.Shift = 0
+	registers.ShiftCount = 0
+}
+
+func (mapper *MMC1) chrOffset(address uint16) int {
+	registers := &mapper.Registers
+	var offset int
+	if registers.Control&0x10 == 0 { // 8 KB
+		offset = int(registers.CHRBank0&0x1E)*0x1000 + int(address)
+	} else if address < 0x1000 { // Two 4 KB banks
+		offset = int(registers.CHRBank0&0x1F)*0x1000 + int(address)
+	} else {
+		offset = int(registers.CHRBank1&0x1F)*0x1000 + int(address&0x0FFF)
+	}
+	return offset % len(mapper.cartridge.CHR_ROM)
+}
+
+func (mapper *MMC1) ReadCHR(address uint16) uint8 {
+	return mapper.cartridge.CHR_ROM[mapper.chrOffset(address)]
+}
+
+func (mapper *MMC1) WriteCHR(address uint16, value uint8) {
+	if mapper.cartridge.CHR_RAM {
+		mapper.cartridge.CHR_ROM[mapper.chrOffset(address)] = value
+	}
+}
+
+func (mapper *MMC1) Mirroring() uint8 {
+	if mapper.cartridge.Header.IgnoreMorriring { // Four-screen boards ignore the register
+		return MIRRORING_FOUR_SCREEN
+	}
+	switch mapper.Registers.Control & 0x03 {
+	case 0:
+		return MIRRORING_SINGLE_LOWER
+	case 1:
+		return MIRRORING_SINGLE_UPPER
+	case 2:
+		return MIRRORING_VERTICAL
+	default:
+		return MIRRORING_HORIZONTAL
+	}
+}
+
+func (mapper *MMC1) SaveState(writer io.Writer) error {
+	if err := binary.Write(writer, binary.LittleEndian, &mapper.Registers); err != nil {
+		return err
+	}
+	return mapper.cartridge.saveMemory(writer)
+}
+
+func (mapper *MMC1) LoadState(reader io.Reader) error {
+	if err := binary.Read(reader, binary.LittleEndian, &mapper.Registers); err != nil {
+		return err
+	}
+	return mapper.cartridge.loadMemory(reader)
+}
@@ -0,0 +1,208 @@
+package internals
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// https://wiki.nesdev.org/w/index.php?title=MMC3
+type MMC3 struct {
+	baseMapper
+	cartridge *Cartridge
+	Registers MMC3Registers
+}
+
+type MMC3Registers struct {
+	BankSelect  uint8 // CPMx xRRR: CHR inversion, PRG mode, register to update
+	Banks       [8]uint8
+	Mirroring   uint8
+	RAMProtect  uint8 // RWxx xxxx: PRG-RAM enabled, writes denied
+	IRQLatch    uint8
+	IRQCounter  uint8
+	IRQReload   bool
+	IRQEnabled  bool
+	IRQPending  bool
+	A12         bool
+	A12LowSince uint64 // PPU time when A12 went low
+}
+
+// A rising edge of A12 only clocks the counter if A12 was low for a while, which filters
+// out the edges between the background fetches
+const mmc3A12Filter = 10 // PPU cycles
+
+func init() {
+	RegisterMapper(4, 0x2000, func(cartridge *Cartridge) Mapper {
+		mapper := &MMC3{cartridge: cartridge}
+		mapper.Registers.Mirroring = 0
+		mapper.Registers.RAMProtect = 0x80
+		return mapper
+	})
+}
+
+func (mapper *MMC3) ReadPRG(address uint16) uint8 {
+	switch {
+	case address < 0x6000:
+		return 0
+	case address < 0x8000:
+		if mapper.Registers.RAMProtect&0x80 == 0 {
+			return 0
+		}
+		return mapper.cartridge.RAM[address-0x6000]
+	default:
+		return mapper.cartridge.PRG_ROM[mapper.prgOffset(address)]
+	}
+}
+
+func (mapper *MMC3) prgOffset(address uint16) int {
+	registers := &mapper.Registers
+	count := len(mapper.cartridge.PRG_ROM) / 0x2000
+	secondLast := count - 2
+	if secondLast < 0 {
+		secondLast = 0
+	}
+
+	var bank int
+	switch slot := (address - 0x8000) / 0x2000; slot {
+	case 0:
+		if registers.BankSelect&0x40 == 0 {
+			bank = int(registers.Banks[6])
+		} else {
+			bank = secondLast
+		}
+	case 1:
+		bank = int(registers.Banks[7])
+	case 2:
+		if registers.BankSelect&0x40 == 0 {
+			bank = secondLast
+		} else {
+			bank = int(registers.Banks[6])
+		}
+	default:
+		bank = count - 1
+		if bank < 0 {
+			bank = 0
+		}
+	}
+	return (bank*0x2000 + int(address&0x1FFF)) % len(mapper.cartridge.PRG_ROM)
+}
+
+func (mapper *MMC3) WritePRG(address uint16, value uint8) {
+	registers := &mapper.Registers
+	switch {
+	case address < 0x6000:
+	case address < 0x8000:
+		if registers.RAMProtect&0xC0 == 0x80 {
+			mapper.cartridge.RAM[address-0x6000] = value
+		}
+	case address < 0xA000:
+		if address%2 == 0 {
+			registers.BankSelect = value
+		} else {
+			registers.Banks[registers.BankSelect&0x07] = value
+		}
+	case address < 0xC000:
+		if address%2 == 0 {
+			registers.Mirroring = value & 0x01
+		} else {
+			registers.RAMProtect = value
+		}
+	case address < 0xE000:
+		if address%2 == 0 {
+			registers.IRQLatch = value
+		} else {
+			registers.IRQCounter = 0
+			registers.IRQReload = true
+		}
+	default:
+		if address%2 == 0 {
+			registers.IRQEnabled = false
+			registers.IRQPending = false
+		} else {
+			registers.IRQEnabled = true
+		}
+	}
+}
+
+func (mapper *MMC3) chrOffset(address uint16) int {
+	registers := &mapper.Registers
+	if registers.BankSelect&0x80 != 0 {
+		address ^= 0x1000
+	}
+
+	var offset int
+	switch {
+	case address < 0x0800:
+		offset = int(registers.Banks[0]&0xFE)*0x400 + int(address)
+	case address < 0x1000:
+		offset = int(registers.Banks[1]&0xFE)*0x400 + int(address-0x0800)
+	default:
+		offset = int(registers.Banks[2+(address-0x1000)/0x400])*0x400 + int(address&0x03FF)
+	}
+	return offset % len(mapper.cartridge.CHR_ROM)
+}
+
+func (mapper *MMC3) ReadCHR(address uint16) uint8 {
+	return mapper.cartridge.CHR_ROM[mapper.chrOffset(address)]
+}
+
+func (mapper *MMC3) WriteCHR(address uint16, value uint8) {
+	if mapper.cartridge.CHR_RAM {
+		mapper.cartridge.CHR_ROM[mapper.chrOffset(address)] = value
+	}
+}
+
+func (mapper *MMC3) Mirroring() uint8 {
+	if mapper.cartridge.Header.IgnoreMorriring { // Four-screen boards ignore the register
+		return MIRRORING_FOUR_SCREEN
+	}
+	if mapper.Registers.Mirroring == 0 {
+		return MIRRORING_VERTICAL
+	}
+	return MIRRORING_HORIZONTAL
+}
+
+func (mapper *MMC3) IRQ() bool {
+	return mapper.Registers.IRQPending
+}
+
+func (mapper *MMC3) PPUAddress(address uint16) {
+	registers := &mapper.Registers
+	ppu := mapper.cartridge.Bus.nes.PPU
+	time := (ppu.FrameCount*262+ppu.Line)*341 + ppu.CycleCount
+
+	a12 := address&0x1000 != 0
+	if a12 && !registers.A12 && time-registers.A12LowSince >= mmc3A12Filter {
+		mapper.clockCounter()
+	}
+	if !a12 && registers.A12 {
+		registers.A12LowSince = time
+	}
+	registers.A12 = a12
+}
+
+func (mapper *MMC3) clockCounter() {
+	registers := &mapper.Registers
+	if registers.IRQCounter == 0 || registers.IRQReload {
+		registers.IRQCounter = registers.IRQLatch
+		registers.IRQReload = false
+	} else {
+		registers.IRQCounter--
+	}
+	if registers.IRQCounter == 0 && registers.IRQEnabled {
+		registers.IRQPending = true
+	}
+}
+
+func (mapper *MMC3) SaveState(writer io.Writer) error {
+	if err := binary.Write(writer, binary.LittleEndian, &mapper.Registers); err != nil {
+		return err
+	}
+	return mapper.cartridge.saveMemory(writer)
+}
+
+func (mapper *MMC3) LoadState(reader io.Reader) error {
+	if err := binary.Read(reader, binary.LittleEndian, &mapper.Registers); err != nil {
+		return err
+	}
+	return mapper.cartridge.loadMemory(reader)
+}
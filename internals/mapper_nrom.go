@@ -0,0 +1,55 @@
+package internals
+
+import "io"
+
+// https://wiki.nesdev.org/w/index.php?title=NROM
+type NROM struct {
+	baseMapper
+	cartridge *Cartridge
+}
+
+func init() {
+	RegisterMapper(0, 0x4000, func(cartridge *Cartridge) Mapper {
+		return &NROM{cartridge: cartridge}
+	})
+}
+
+func (mapper *NROM) ReadPRG(address uint16) uint8 {
+	switch {
+	case address < 0x6000:
+		return 0
+	case address < 0x8000:
+		return mapper.cartridge.RAM[address-0x6000]
+	default:
+		return mapper.cartridge.PRG_ROM[(address-0x8000)%uint16(len(mapper.cartridge.PRG_ROM))]
+	}
+}
+
+func (mapper *NROM) WritePRG(address uint16, value uint8) {
+	if address >= 0x6000 && address < 0x8000 {
+		mapper.cartridge.RAM[address-0x6000] = value
+	}
+}
+
+func (mapper *NROM) ReadCHR(address uint16) uint8 {
+	return mapper.cartridge.CHR_ROM[int(address)%len(mapper.cartridge.CHR_ROM)]
+}
+
+func (mapper *NROM) WriteCHR(address uint16, value uint8) {
+	if mapper.cartridge.CHR_RAM {
+		mapper.cartridge.CHR_ROM[int(address)%len(mapper.cartridge.CHR_ROM)] = value
+	}
+}
+
+func (mapper *NROM) Mirroring() uint8 {
+	return headerMirroring(&mapper.cartridge.Header)
+}
+
+// NROM has no registers, only the memory is saved
+func (mapper *NROM) SaveState(writer io.Writer) error {
+	return mapper.cartridge.saveMemory(writer)
+}
+
+func (mapper *NROM) LoadState(reader io.Reader) error {
+	return mapper.cartridge.loadMemory(reader)
+}
@@ -0,0 +1,317 @@
+package internals
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Every 8 KB of PRG-ROM and 1 KB of CHR-ROM starts with its index
+func newTestNES(mapper uint, prgSize int, chrSize int) *NES {
+	nes := NewNES()
+	cartridge := nes.Cartridge
+	cartridge.Header.Mapper = mapper
+	cartridge.PRG_ROM = make([]byte, prgSize)
+	for i := 0; i < prgSize; i += 0x2000 {
+		cartridge.PRG_ROM[i] = uint8(i / 0x2000)
+	}
+	cartridge.Header.CHR_ROM_size = uint(chrSize)
+	cartridge.allocateCHR()
+	for i := 0; i < chrSize; i += 0x400 {
+		cartridge.CHR_ROM[i] = uint8(i / 0x400)
+	}
+	cartridge.Header.PRG_ROM_size = uint(prgSize)
+	cartridge.Mapper, _ = newMapper(cartridge)
+	return nes
+}
+
+// Writes to the bus as separate instructions would
+func writeInstruction(nes *NES, address uint16, value uint8) {
+	nes.CPU.CycleCount += 4
+	nes.Bus.Write(address, value)
+}
+
+func writeMMC1(nes *NES, address uint16, value uint8) {
+	for i := 0; i < 5; i++ {
+		writeInstruction(nes, address, (value>>i)&1)
+	}
+}
+
+func TestMMC1(t *testing.T) {
+	nes := newTestNES(1, 256*1024, 128*1024)
+
+	if nes.Bus.Read(0x8000) != 0 || nes.Bus.Read(0xC000) != 30 {
+		t.Error("The last bank should be fixed at 0xC000 on power up")
+	}
+
+	writeMMC1(nes, 0xE000, 5)
+	if nes.Bus.Read(0x8000) != 10 || nes.Bus.Read(0xC000) != 30 {
+		t.Error("PRG bank not switched: ", nes.Bus.Read(0x8000))
+	}
+
+	writeMMC1(nes, 0x8000, 0x08|0x10|0x02) // First bank fixed, 4 KB CHR banks, vertical mirroring
+	if nes.Bus.Read(0x8000) != 0 || nes.Bus.Read(0xC000) != 10 {
+		t.Error("PRG mode not changed")
+	}
+	if nes.Cartridge.Mapper.Mirroring() != MIRRORING_VERTICAL {
+		t.Error("Mirroring not changed")
+	}
+	nes.Cartridge.Header.IgnoreMorriring = true
+	if nes.Cartridge.Mapper.Mirroring() != MIRRORING_FOUR_SCREEN {
+		t.Error("Four-screen VRAM not used")
+	}
+	nes.Cartridge.Header.IgnoreMorriring = false
+
+	writeMMC1(nes, 0xA000, 3)
+	writeMMC1(nes, 0xC000, 7)
+	if nes.Cartridge.Read(0x0000) != 12 || nes.Cartridge.Read(0x1000) != 28 {
+		t.Error("CHR banks not switched")
+	}
+
+	// A write with bit 7 set resets the shift register
+	writeInstruction(nes, 0xE000, 1)
+	writeInstruction(nes, 0xE000, 0x80)
+	writeMMC1(nes, 0xE000, 2)
+	if nes.Bus.Read(0x8000) != 4 {
+		t.Error("Shift register not reset")
+	}
+
+	// The second of two consecutive writes is ignored
+	writeInstruction(nes, 0xE000, 1)
+	nes.Bus.Write(0xE000, 0)
+	for i := 0; i < 4; i++ {
+		writeInstruction(nes, 0xE000, 0)
+	}
+	if nes.Bus.Read(0x8000) != 2 {
+		t.Error("Consecutive write not ignored: ", nes.Bus.Read(0x8000))
+	}
+
+	// PRG-RAM is disabled by bit 4 of the PRG bank
+	nes.Bus.Write(0x6000, 0x42)
+	writeMMC1(nes, 0xE000, 0x10)
+	if nes.Bus.Read(0x6000) != 0 {
+		t.Error("PRG-RAM not disabled")
+	}
+	writeMMC1(nes, 0xE000, 0x00)
+	if nes.Bus.Read(0x6000) != 0x42 {
+		t.Error("PRG-RAM not enabled")
+	}
+
+	var state bytes.Buffer
+	nes.Cartridge.Mapper.SaveState(&state)
+	writeMMC1(nes, 0xE000, 7)
+	nes.Cartridge.Mapper.LoadState(&state)
+	if nes.Bus.Read(0x8000) != 0 {
+		t.Error("State not restored")
+	}
+}
+
+func TestMMC3(t *testing.T) {
+	nes := newTestNES(4, 128*1024, 128*1024)
+
+	writeInstruction(nes, 0x8000, 6)
+	writeInstruction(nes, 0x8001, 3)
+	writeInstruction(nes, 0x8000, 7)
+	writeInstruction(nes, 0x8001, 5)
+	if nes.Bus.Read(0x8000) != 3 || nes.Bus.Read(0xA000) != 5 || nes.Bus.Read(0xC000) != 14 || nes.Bus.Read(0xE000) != 15 {
+		t.Error("PRG banks not switched")
+	}
+	writeInstruction(nes, 0x8000, 0x40)
+	if nes.Bus.Read(0x8000) != 14 || nes.Bus.Read(0xC000) != 3 {
+		t.Error("PRG mode not changed")
+	}
+
+	writeInstruction(nes, 0x8000, 0)
+	writeInstruction(nes, 0x8001, 9) // The lowest bit of the 2 KB banks is ignored
+	writeInstruction(nes, 0x8000, 5)
+	writeInstruction(nes, 0x8001, 20)
+	if nes.Cartridge.Read(0x0000) != 8 || nes.Cartridge.Read(0x0400) != 9 || nes.Cartridge.Read(0x1C00) != 20 {
+		t.Error("CHR banks not switched")
+	}
+	writeInstruction(nes, 0x8000, 0x80)
+	if nes.Cartridge.Read(0x1000) != 8 || nes.Cartridge.Read(0x0C00) != 20 {
+		t.Error("CHR inversion not applied")
+	}
+
+	writeInstruction(nes, 0xA000, 1)
+	if nes.Cartridge.Mapper.Mirroring() != MIRRORING_HORIZONTAL {
+		t.Error("Mirroring not changed")
+	}
+
+	writeInstruction(nes, 0xA001, 0xC0)
+	nes.Bus.Write(0x6000, 0x42)
+	writeInstruction(nes, 0xA001, 0x80)
+	if nes.Bus.Read(0x6000) != 0 {
+		t.Error("Write protected PRG-RAM was written")
+	}
+
+	// Background from 0x0000 and sprites from 0x1000: A12 rises once per scanline
+	nes.PPU.Initialize()
+	nes.PPU.Registers.PPUCTRL.SpritePatternTableBase = 0x1000
+	nes.PPU.Registers.PPUMASK.ShowBackground = true
+	nes.PPU.Registers.PPUMASK.ShowSprites = true
+	writeInstruction(nes, 0xC000, 10)
+	writeInstruction(nes, 0xC001, 0)
+	writeInstruction(nes, 0xE001, 0)
+
+	for i := 0; i < 341*262 && !nes.Cartridge.Mapper.IRQ(); i++ {
+		nes.PPU.Cycle()
+	}
+	if !nes.Cartridge.Mapper.IRQ() || nes.PPU.Line != 9 {
+		t.Error("IRQ not raised after 11 scanlines: ", nes.PPU.Line)
+	}
+
+	writeInstruction(nes, 0xE000, 0)
+	if nes.Cartridge.Mapper.IRQ() {
+		t.Error("IRQ not acknowledged")
+	}
+
+	var state bytes.Buffer
+	if err := nes.Cartridge.Mapper.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+	writeInstruction(nes, 0x8000, 0)
+	if err := nes.Cartridge.Mapper.LoadState(&state); err != nil {
+		t.Fatal(err)
+	}
+	if nes.Cartridge.Read(0x1000) != 8 {
+		t.Error("State not restored")
+	}
+}
+
+func TestDiscreteMappers(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapper  uint
+		value   uint8
+		address uint16
+		prg     uint8 // Index of the 8 KB bank at address
+		chr     uint8 // Index of the 1 KB bank at 0x0000
+	}{
+		{"UxROM", 2, 3, 0x8000, 6, 0},
+		{"UxROM fixed bank", 2, 3, 0xC000, 14, 0},
+		{"CNROM", 3, 2, 0x8000, 0, 16},
+		{"AxROM", 7, 0x12, 0x8000, 8, 0},
+		{"Color Dreams", 11, 0x21, 0x8000, 4, 16},
+		{"GxROM", 66, 0x12, 0x8000, 4, 16},
+	}
+
+	for _, test := range tests {
+		nes := newTestNES(test.mapper, 128*1024, 32*1024)
+		// Avoids the bus conflicts
+		for i := range nes.Cartridge.PRG_ROM {
+			if i%0x2000 != 0 {
+				nes.Cartridge.PRG_ROM[i] = 0xFF
+			}
+		}
+		nes.Bus.Write(0x8001, test.value)
+		if value := nes.Bus.Read(test.address); value != test.prg {
+			t.Errorf("%s: PRG bank %d, expected %d", test.name, value, test.prg)
+		}
+		if value := nes.Cartridge.Read(0x0000); value != test.chr {
+			t.Errorf("%s: CHR bank %d, expected %d", test.name, value, test.chr)
+		}
+	}
+
+	nes := newTestNES(7, 128*1024, 8*1024)
+	nes.Bus.Write(0x8000, 0x10)
+	if nes.Cartridge.Mapper.Mirroring() != MIRRORING_SINGLE_UPPER {
+		t.Error("AxROM mirroring not changed")
+	}
+
+	// The ROM at 0x8000 contains 0, the register is cleared
+	nes = newTestNES(2, 128*1024, 8*1024)
+	nes.Bus.Write(0x8000, 3)
+	if nes.Bus.Read(0x8000) != 0 {
+		t.Error("Bus conflict not emulated")
+	}
+}
+
+func TestCHRRAM(t *testing.T) {
+	nes := newTestNES(2, 128*1024, 0)
+	if !nes.Cartridge.CHR_RAM || len(nes.Cartridge.CHR_ROM) != 0x2000 {
+		t.Fatal("CHR-RAM not allocated")
+	}
+	nes.PPU.Write(0x1234, 0x42)
+	if nes.PPU.Read(0x1234) != 0x42 {
+		t.Error("CHR-RAM write not persisted")
+	}
+
+	nes = newTestNES(0, 32*1024, 8*1024)
+	nes.PPU.Write(0x0000, 0x42)
+	if nes.PPU.Read(0x0000) != 0 {
+		t.Error("CHR-ROM written")
+	}
+}
+
+// Maps the quadrants in reverse order
+type reversedNametables struct {
+	NROM
+}
+
+func (mapper *reversedNametables) Nametable(quadrant uint8) uint8 {
+	return 3 - quadrant
+}
+
+func TestNametableMirroring(t *testing.T) {
+	tests := []struct {
+		name       string
+		mirroring  bool
+		fourScreen bool
+		custom     bool
+		nametables [4]uint8
+	}{
+		{"Horizontal", false, false, false, [4]uint8{0, 0, 1, 1}},
+		{"Vertical", true, false, false, [4]uint8{0, 1, 0, 1}},
+		{"Four-screen", false, true, false, [4]uint8{0, 1, 2, 3}},
+		{"Mapper controlled", false, false, true, [4]uint8{3, 2, 1, 0}},
+	}
+
+	for _, test := range tests {
+		nes := newTestNES(0, 32*1024, 8*1024)
+		nes.Cartridge.Header.Mirroring = test.mirroring
+		nes.Cartridge.Header.IgnoreMorriring = test.fourScreen
+		if test.custom {
+			nes.Cartridge.Mapper = &reversedNametables{NROM{cartridge: nes.Cartridge}}
+		}
+		for quadrant := uint16(0); quadrant < 4; quadrant++ {
+			nes.PPU.Write(0x2000+quadrant*0x400+5, uint8(quadrant+1))
+		}
+		for quadrant, nametable := range test.nametables {
+			if nes.PPU.Nametables[uint16(nametable)*0x400+5] == 0 {
+				t.Errorf("%s: quadrant %d not mapped to name table %d", test.name, quadrant, nametable)
+			}
+			// 0x3000 - 0x3EFF mirrors the name tables
+			if nes.PPU.Read(0x3000+uint16(quadrant)*0x400+5) != nes.PPU.Nametables[uint16(nametable)*0x400+5] {
+				t.Errorf("%s: 0x3000 mirror differs", test.name)
+			}
+		}
+	}
+
+	nes := newTestNES(7, 128*1024, 8*1024)
+	nes.Bus.Write(0x8000, 0x10)
+	nes.PPU.Write(0x2000, 0x42)
+	if nes.PPU.Nametables[0x400] != 0x42 || nes.PPU.Read(0x2C00) != 0x42 {
+		t.Error("Single-screen B not used")
+	}
+}
+
+func TestSaveStateMemory(t *testing.T) {
+	for _, mapper := range []uint{0, 1, 2, 4} {
+		nes := newTestNES(mapper, 32*1024, 0)
+		nes.Cartridge.Write(0x0010, 0x42)
+		nes.Cartridge.RAM[0x10] = 0x24
+
+		var state bytes.Buffer
+		if err := nes.Cartridge.Mapper.SaveState(&state); err != nil {
+			t.Fatal(err)
+		}
+		nes.Cartridge.Write(0x0010, 0x00)
+		nes.Cartridge.RAM[0x10] = 0x00
+		if err := nes.Cartridge.Mapper.LoadState(&state); err != nil {
+			t.Fatal(err)
+		}
+		if nes.Cartridge.Read(0x0010) != 0x42 || nes.Cartridge.RAM[0x10] != 0x24 {
+			t.Errorf("Mapper %d: CHR-RAM and PRG-RAM not restored", mapper)
+		}
+	}
+}
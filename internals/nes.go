@@ -1,8 +1,23 @@
 package internals
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
-	"strconv"
+	"os"
+	"path/filepath"
+
+	"github.com/hiumee/NES/internals/patch"
+)
+
+var (
+	ErrBadMagic  = errors.New("unknown file format")
+	ErrTruncated = errors.New("file is truncated")
+	ErrNoPRGROM  = errors.New("cartridge has no PRG-ROM")
 )
 
 type NES struct {
@@ -10,9 +25,27 @@ type NES struct {
 	APU         *APU
 	PPU         *PPU
 	Cartridge   *Cartridge
+	NSF         *NSF // Set instead of the cartridge when playing a music file
+	FDS         *FDS // Set when playing a disk image, it is also the mapper of the cartridge
 	Bus         *Bus
 	Controllers [2]Controller
 	RAM         [0x2000]uint8
+	Audio       *Audio // Optional, nil when the sound is not used
+
+	// Optional, the output of each channel on its own
+	Stems [CHANNEL_COUNT]*Audio
+
+	// Battery-backed RAM, see LoadBattery and SaveBattery
+	Storage  SaveStorage
+	SaveName string
+	savedRAM [0x2000]uint8
+
+	// Patch files applied in order by LoadFile
+	Patches []string
+	// Entry of the .zip archive loaded by LoadFile, by default the first ROM
+	ArchiveEntry string
+
+	FDSBIOS []byte // Needed to load disk images
 }
 
 func NewNES() *NES {
@@ -20,80 +53,222 @@ func NewNES() *NES {
 	var bus *Bus = &Bus{}
 	var cpu *CPU = &CPU{}
 	var ppu *PPU = &PPU{}
+	var apu *APU = &APU{}
 	nes.Bus = bus
 	bus.nes = &nes
 	cpu.Bus = bus
 	ppu.Bus = bus
+	apu.Bus = bus
 	nes.CPU = cpu
 	nes.PPU = ppu
-	nes.Cartridge = &Cartridge{}
+	nes.APU = apu
+	for channel := range apu.Channels {
+		apu.Channels[channel].Volume = 1
+	}
+	nes.Cartridge = &Cartridge{Bus: bus}
+	nes.Storage = FileStorage{}
 
 	return &nes
 }
 
-func (nes *NES) LoadFile(filename string) {
-	data, err := ioutil.ReadFile(filename)
+// Reads a patch file and applies it to the data
+func applyPatch(data []byte, filename string) ([]byte, error) {
+	patchData, err := os.ReadFile(filename)
 	if err != nil {
-		panic("Could not read the input file")
+		return nil, err
 	}
+	data, err = patch.Apply(data, patchData)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(filename), err)
+	}
+	return data, nil
+}
 
-	if data[0] != 'N' || data[1] != 'E' || data[2] != 'S' || data[3] != 0x1A {
-		panic("Invalid file format")
+// Loads a file, after decompressing it and applying the patches. When Patches is empty, a patch
+// with the name of the file and the .ips, .ups or .bps extension is applied if it exists
+func (nes *NES) LoadFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	data, err = decompress(data, nes.ArchiveEntry)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(filename), err)
 	}
 
-	nes.Cartridge.Header.PRG_ROM_size = uint(data[4]) * 16 * 1024
-	nes.Cartridge.Header.CHR_ROM_size = uint(data[5]) * 8 * 1024
+	patches := nes.Patches
+	if len(patches) == 0 {
+		base := filename[:len(filename)-len(filepath.Ext(filename))]
+		for _, extension := range []string{".ips", ".ups", ".bps"} {
+			if _, err := os.Stat(base + extension); err == nil {
+				patches = []string{base + extension}
+				break
+			}
+		}
+	}
+	for _, name := range patches {
+		data, err = applyPatch(data, name)
+		if err != nil {
+			return err
+		}
+	}
 
-	// Flags 6
-	/*
-		Mirroring
-		Battery RAM
-		Trainer
-		Ignore mirroring
-		Lower nibble of Mapper #
-	*/
-	flags6 := data[6]
-	nes.Cartridge.Header.Mirroring = flags6&(0x1<<0) == 1
-	nes.Cartridge.Header.PersistentRAM = flags6&(0x1<<1) == 1
-	nes.Cartridge.Header.Trainer = flags6&(0x1<<2) == 1
-	nes.Cartridge.Header.IgnoreMorriring = flags6&(0x1<<3) == 1
-	nes.Cartridge.Header.Mapper = uint(flags6&0xF0) >> 4
+	if err := nes.LoadROM(bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	nes.SaveName = SaveName(filename)
+	return nes.LoadBattery()
+}
 
-	if nes.Cartridge.Header.Mapper != 0 {
-		panic("Unsupported mapper: " + strconv.Itoa(int(nes.Cartridge.Header.Mapper)))
+// Loads an iNES, NES 2.0, UNIF, NSF, NSFe or FDS file
+func (nes *NES) LoadROM(reader io.Reader) error {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
 	}
 
-	// Flags 7
-	/*
-		VS Unisystem
-		PlayChoice - Ignore
-		NES2.0 Format - Ignore [2-3]
-		Upper nibble of Mapper #
-	*/
-	flags7 := data[7]
-	nes.Cartridge.Header.VSUnisystem = flags7&(0x1<<0) == 1
-	nes.Cartridge.Header.Mapper = uint(flags7&0xF0) | nes.Cartridge.Header.Mapper
+	if isNSF(data) {
+		nsf, err := parseNSF(data)
+		if err != nil {
+			return err
+		}
+		nes.NSF = nsf
+		nes.NSF.Bus = nes.Bus
+		// The PPU still reaches the cartridge, music files get CHR-RAM on an NROM board
+		nes.Cartridge.Header = Header{CHR_RAM_size: 0x2000}
+		nes.Cartridge.allocateCHR()
+		nes.Cartridge.Mapper = &NROM{cartridge: nes.Cartridge}
+		nes.Initialize()
+		nes.PlayTrack(nes.NSF.StartingTrack)
+		return nil
+	}
 
-	nes.Cartridge.Header.PRG_RAM_size = uint(data[8]) * 8 * 1024
+	if isFDS(data) {
+		return nes.loadFDS(data)
+	}
 
-	nes.Cartridge.PRG_ROM = make([]byte, nes.Cartridge.Header.PRG_ROM_size)
-	nes.Cartridge.CHR_ROM = make([]byte, nes.Cartridge.Header.CHR_ROM_size)
+	if isUNIF(data) {
+		nes.Cartridge.Header = Header{}
+		prg, chr, err := parseUNIF(&nes.Cartridge.Header, data)
+		if err != nil {
+			return err
+		}
+		return nes.loadCartridge(nil, prg, chr)
+	}
 
-	nes.Cartridge.Loaded = true
+	if len(data) < 4 || data[0] != 'N' || data[1] != 'E' || data[2] != 'S' || data[3] != 0x1A {
+		return ErrBadMagic
+	}
+	if len(data) < 16 {
+		return fmt.Errorf("%w: header", ErrTruncated)
+	}
 
+	nes.Cartridge.Header = parseHeader(data)
+	header := &nes.Cartridge.Header
+
+	if header.PRG_ROM_size == 0 {
+		return ErrNoPRGROM
+	}
 	var pointer uint = 16
-	memcpy(nes.Cartridge.PRG_ROM, data[pointer:(pointer+nes.Cartridge.Header.PRG_ROM_size)], nes.Cartridge.Header.PRG_ROM_size)
-	pointer += nes.Cartridge.Header.PRG_ROM_size
-	memcpy(nes.Cartridge.CHR_ROM, data[pointer:(pointer+nes.Cartridge.Header.CHR_ROM_size)], nes.Cartridge.Header.CHR_ROM_size)
-	pointer += nes.Cartridge.Header.CHR_ROM_size
+	var trainerSize uint
+	if header.Trainer {
+		trainerSize = 512
+	}
+	// Each size is checked on its own first, so the sum can't overflow
+	available := uint(len(data)) - pointer
+	if header.PRG_ROM_size > available || header.CHR_ROM_size > available ||
+		trainerSize+header.PRG_ROM_size+header.CHR_ROM_size > available {
+		return fmt.Errorf("%w: expected %d bytes of PRG-ROM and %d bytes of CHR-ROM, got %d bytes",
+			ErrTruncated, header.PRG_ROM_size, header.CHR_ROM_size, available)
+	}
+
+	trainer := data[pointer : pointer+trainerSize]
+	pointer += trainerSize
+	prg := data[pointer : pointer+header.PRG_ROM_size]
+	pointer += header.PRG_ROM_size
+	chr := data[pointer : pointer+header.CHR_ROM_size]
+	return nes.loadCartridge(trainer, prg, chr)
+}
+
+// Sets up the cartridge once the header is known, whatever the file format
+func (nes *NES) loadCartridge(trainer []byte, prg []byte, chr []byte) error {
+	header := &nes.Cartridge.Header
+	header.PRG_ROM_size = uint(len(prg))
+	header.CHR_ROM_size = uint(len(chr))
+
+	// Bad dumps are fixed using the game database
+	rom := append(append([]byte{}, prg...), chr...)
+	nes.Cartridge.CRC32 = crc32.ChecksumIEEE(rom)
+	nes.Cartridge.SHA1 = sha1.Sum(rom)
+	nes.Cartridge.Game = EmbeddedGameDB().Lookup(rom)
+	if nes.Cartridge.Game != nil {
+		header.applyGameInfo(nes.Cartridge.Game)
+	}
+
+	nes.Cartridge.PRG_ROM = make([]byte, header.PRG_ROM_size)
+	nes.Cartridge.allocateCHR()
+	memcpy(nes.Cartridge.PRG_ROM, prg, header.PRG_ROM_size)
+	memcpy(nes.Cartridge.CHR_ROM, chr, header.CHR_ROM_size)
+	// The trainer is loaded at 0x7000
+	memcpy(nes.Cartridge.RAM[0x1000:], trainer, uint(len(trainer)))
+
+	mapper, err := newMapper(nes.Cartridge)
+	if err != nil {
+		return err
+	}
+	nes.Cartridge.Mapper = mapper
+	nes.Cartridge.Loaded = true
 
 	nes.Initialize()
+	return nil
+}
+
+func (nes *NES) loadFDS(data []byte) error {
+	fds, err := parseFDS(data, nes.FDSBIOS)
+	if err != nil {
+		return err
+	}
+	fds.Bus = nes.Bus
+	nes.FDS = fds
+
+	nes.Cartridge.Header.CHR_RAM_size = 0x2000
+	nes.Cartridge.allocateCHR()
+	nes.Cartridge.Mapper = fds
+	nes.Cartridge.Loaded = true
+
+	nes.Initialize()
+	return nil
 }
 
 func (nes *NES) Initialize() {
 	nes.CPU.PowerUp()
 	nes.PPU.Initialize()
-	//nes.APU.Initialize()
+	nes.APU.Initialize()
+}
+
+// Starts playing a track (0 based) of the loaded NSF file
+func (nes *NES) PlayTrack(track int) {
+	if track < 0 || track >= nes.NSF.Tracks {
+		track = 0
+	}
+	nes.NSF.InitTrack(track)
+}
+
+func (nes *NES) SetAudioSink(sink AudioSink) {
+	if sink == nil {
+		nes.Audio = nil
+		return
+	}
+	nes.Audio = NewAudio(sink)
+}
+
+func (nes *NES) SetStemSink(channel int, sink AudioSink) {
+	if sink == nil {
+		nes.Stems[channel] = nil
+		return
+	}
+	nes.Stems[channel] = NewAudio(sink)
 }
 
 func (nes *NES) Step() uint64 {
@@ -101,6 +276,24 @@ func (nes *NES) Step() uint64 {
 
 	// For each CPU cycle, there are 3 PPU cycles at the same time
 	nes.CPU.Cycle()
+	if nes.NSF != nil {
+		nes.NSF.Cycle()
+	}
+	if nes.FDS != nil {
+		nes.FDS.Cycle()
+	}
+	nes.APU.Cycle()
+	if nes.Cartridge.Mapper != nil && nes.Cartridge.Mapper.IRQ() {
+		nes.CPU.InterruptIRQ()
+	}
+	if nes.Audio != nil {
+		nes.Audio.AddSample(nes.APU.Output())
+	}
+	for channel, stem := range nes.Stems {
+		if stem != nil {
+			stem.AddSample(nes.APU.ChannelOutput(channel))
+		}
+	}
 	nes.PPU.Cycle()
 	nes.PPU.Cycle()
 	nes.PPU.Cycle()
@@ -0,0 +1,314 @@
+package internals
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// https://wiki.nesdev.org/w/index.php?title=NSF
+// https://wiki.nesdev.org/w/index.php?title=NSFe
+
+const (
+	// The CPU waits in a JMP to itself at this address between the INIT and PLAY calls
+	NSF_DRIVER = 0x5FF0
+
+	NSF_DEFAULT_SPEED    = 16639  // µs, ~60 Hz
+	NSF_DEFAULT_DURATION = 150000 // ms, used for tracks without a known length
+)
+
+type NSF struct {
+	Bus *Bus
+
+	Version        uint8
+	Tracks         int
+	StartingTrack  int // 0 based
+	LoadAddress    uint16
+	InitAddress    uint16
+	PlayAddress    uint16
+	PlaySpeed      uint16 // NTSC, in µs
+	Title          string
+	Artist         string
+	Copyright      string
+	Ripper         string
+	TrackLabels    []string // Empty strings when unknown
+	TrackTimes     []int32  // In ms, -1 when unknown
+	InitialBanks   [8]uint8
+	Bankswitched   bool
+	ExpansionChips uint8 // Not emulated
+
+	Data  []byte // Padded so that bank 0 starts at the beginning
+	Banks [8]uint8
+	RAM   [0x2000]uint8
+	Track int
+
+	initDone bool
+	timer    uint64
+}
+
+func isNSF(data []byte) bool {
+	return (len(data) >= 5 && string(data[0:5]) == "NESM\x1A") || (len(data) >= 4 && string(data[0:4]) == "NSFE")
+}
+
+func parseNSF(data []byte) (*NSF, error) {
+	var nsf *NSF
+	var err error
+	if string(data[0:4]) == "NSFE" {
+		nsf, err = parseNSFE(data)
+	} else {
+		nsf, err = parseNSFHeader(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if nsf.LoadAddress < 0x8000 {
+		return nil, fmt.Errorf("unsupported NSF load address: %04X", nsf.LoadAddress)
+	}
+	if nsf.PlaySpeed == 0 {
+		nsf.PlaySpeed = NSF_DEFAULT_SPEED
+	}
+	// The header can count tracks from 1 or point past the last one
+	if nsf.Tracks < 1 {
+		nsf.Tracks = 1
+	}
+	if nsf.StartingTrack < 0 {
+		nsf.StartingTrack = 0
+	} else if nsf.StartingTrack >= nsf.Tracks {
+		nsf.StartingTrack = nsf.Tracks - 1
+	}
+	for _, bank := range nsf.InitialBanks {
+		if bank != 0 {
+			nsf.Bankswitched = true
+		}
+	}
+
+	var padding uint16
+	if nsf.Bankswitched {
+		padding = nsf.LoadAddress & 0x0FFF
+	} else {
+		padding = nsf.LoadAddress - 0x8000
+		for i := range nsf.InitialBanks {
+			nsf.InitialBanks[i] = uint8(i)
+		}
+	}
+	nsf.Data = append(make([]byte, padding), nsf.Data...)
+
+	for len(nsf.TrackLabels) < nsf.Tracks {
+		nsf.TrackLabels = append(nsf.TrackLabels, "")
+	}
+	for len(nsf.TrackTimes) < nsf.Tracks {
+		nsf.TrackTimes = append(nsf.TrackTimes, -1)
+	}
+
+	return nsf, nil
+}
+
+func parseNSFHeader(data []byte) (*NSF, error) {
+	if len(data) < 0x80 {
+		return nil, fmt.Errorf("%w: NSF header", ErrTruncated)
+	}
+
+	nsf := &NSF{
+		Version:        data[0x05],
+		Tracks:         int(data[0x06]),
+		StartingTrack:  int(data[0x07]) - 1,
+		LoadAddress:    binary.LittleEndian.Uint16(data[0x08:]),
+		InitAddress:    binary.LittleEndian.Uint16(data[0x0A:]),
+		PlayAddress:    binary.LittleEndian.Uint16(data[0x0C:]),
+		Title:          nsfString(data[0x0E:0x2E]),
+		Artist:         nsfString(data[0x2E:0x4E]),
+		Copyright:      nsfString(data[0x4E:0x6E]),
+		PlaySpeed:      binary.LittleEndian.Uint16(data[0x6E:]),
+		ExpansionChips: data[0x7B],
+	}
+	copy(nsf.InitialBanks[:], data[0x70:0x78])
+
+	programData := data[0x80:]
+	// NSF2 can have metadata after the program data
+	length := int(data[0x7D]) | int(data[0x7E])<<8 | int(data[0x7F])<<16
+	if nsf.Version >= 2 && length > 0 && length < len(programData) {
+		programData = programData[:length]
+	}
+	nsf.Data = append([]byte{}, programData...)
+
+	return nsf, nil
+}
+
+func parseNSFE(data []byte) (*NSF, error) {
+	nsf := &NSF{Tracks: 1}
+	hasInfo := false
+	hasData := false
+
+	pointer := 4
+	for {
+		if pointer+8 > len(data) {
+			return nil, fmt.Errorf("%w: missing NSFe NEND chunk", ErrTruncated)
+		}
+		size := int(binary.LittleEndian.Uint32(data[pointer:]))
+		id := string(data[pointer+4 : pointer+8])
+		pointer += 8
+		if size < 0 || pointer+size > len(data) {
+			return nil, fmt.Errorf("%w: NSFe %s chunk", ErrTruncated, id)
+		}
+		chunk := data[pointer : pointer+size]
+		pointer += size
+
+		switch id {
+		case "INFO":
+			if len(chunk) < 8 {
+				return nil, fmt.Errorf("%w: NSFe INFO chunk", ErrTruncated)
+			}
+			nsf.LoadAddress = binary.LittleEndian.Uint16(chunk[0:])
+			nsf.InitAddress = binary.LittleEndian.Uint16(chunk[2:])
+			nsf.PlayAddress = binary.LittleEndian.Uint16(chunk[4:])
+			nsf.ExpansionChips = chunk[7]
+			if len(chunk) > 8 {
+				nsf.Tracks = int(chunk[8])
+			}
+			if len(chunk) > 9 {
+				nsf.StartingTrack = int(chunk[9])
+			}
+			hasInfo = true
+		case "DATA":
+			nsf.Data = append([]byte{}, chunk...)
+			hasData = true
+		case "BANK":
+			copy(nsf.InitialBanks[:], chunk)
+		case "RATE":
+			if len(chunk) >= 2 {
+				nsf.PlaySpeed = binary.LittleEndian.Uint16(chunk)
+			}
+		case "auth":
+			fields := nsfStrings(chunk)
+			for len(fields) < 4 {
+				fields = append(fields, "")
+			}
+			nsf.Title, nsf.Artist, nsf.Copyright, nsf.Ripper = fields[0], fields[1], fields[2], fields[3]
+		case "tlbl":
+			nsf.TrackLabels = nsfStrings(chunk)
+		case "time":
+			for i := 0; i+4 <= len(chunk); i += 4 {
+				nsf.TrackTimes = append(nsf.TrackTimes, int32(binary.LittleEndian.Uint32(chunk[i:])))
+			}
+		case "NEND":
+			if !hasInfo || !hasData {
+				return nil, errors.New("missing NSFe INFO or DATA chunk")
+			}
+			return nsf, nil
+		default:
+			// Chunks starting with an uppercase letter must be understood to play the file
+			if id[0] >= 'A' && id[0] <= 'Z' {
+				return nil, errors.New("unsupported NSFe chunk: " + id)
+			}
+		}
+	}
+}
+
+func nsfString(data []byte) string {
+	if end := strings.IndexByte(string(data), 0); end >= 0 {
+		data = data[:end]
+	}
+	return string(data)
+}
+
+func nsfStrings(data []byte) []string {
+	fields := strings.Split(string(data), "\x00")
+	if len(fields) > 0 && fields[len(fields)-1] == "" {
+		fields = fields[:len(fields)-1]
+	}
+	return fields
+}
+
+func (nsf *NSF) Read(address uint16) uint8 {
+	switch {
+	case address >= NSF_DRIVER && address < NSF_DRIVER+3: // JMP NSF_DRIVER
+		return []uint8{0x4C, NSF_DRIVER & 0xFF, NSF_DRIVER >> 8}[address-NSF_DRIVER]
+	case address < 0x6000:
+		return 0
+	case address < 0x8000:
+		return nsf.RAM[address-0x6000]
+	default:
+		offset := int(nsf.Banks[(address-0x8000)>>12])*0x1000 + int(address&0x0FFF)
+		if offset < len(nsf.Data) {
+			return nsf.Data[offset]
+		}
+		return 0
+	}
+}
+
+func (nsf *NSF) Write(address uint16, value uint8) {
+	switch {
+	case address >= 0x5FF8 && address <= 0x5FFF:
+		if nsf.Bankswitched {
+			nsf.Banks[address-0x5FF8] = value
+		}
+	case address >= 0x6000 && address < 0x8000:
+		nsf.RAM[address-0x6000] = value
+	}
+}
+
+// Resets the memory and the sound registers, then calls the INIT routine for the track (0 based)
+func (nsf *NSF) InitTrack(track int) {
+	nes := nsf.Bus.nes
+
+	nes.RAM = [0x2000]uint8{}
+	nsf.RAM = [0x2000]uint8{}
+	nsf.Banks = nsf.InitialBanks
+
+	nes.APU.Initialize()
+	for address := uint16(0x4000); address < 0x4014; address++ {
+		nes.APU.WriteRegister(address, 0)
+	}
+	nes.APU.WriteRegister(0x4015, 0x0F)
+	nes.APU.WriteRegister(0x4017, 0x40)
+
+	// The NSF routines don't expect NMIs
+	nes.PPU.WriteRegister(0x2000, 0)
+
+	// Drops the instruction in progress
+	cpu := nes.CPU
+	cpu.Reset()
+	cpu.A = uint8(track)
+	cpu.X = 0 // NTSC
+	cpu.Y = 0
+	cpu.PushAddress(NSF_DRIVER - 1)
+	cpu.PC = nsf.InitAddress
+
+	nsf.Track = track
+	nsf.initDone = false
+	nsf.timer = 0
+}
+
+// Called once for every CPU cycle. Calls the PLAY routine at the play rate, once the CPU is idle
+func (nsf *NSF) Cycle() {
+	cpu := nsf.Bus.nes.CPU
+	idle := cpu.PC == NSF_DRIVER
+
+	if !nsf.initDone {
+		if !idle {
+			return
+		}
+		nsf.initDone = true
+	}
+
+	period := uint64(nsf.PlaySpeed) * CPU_FREQUENCY / 1000000
+	nsf.timer++
+	if nsf.timer >= period && idle {
+		nsf.timer -= period
+		if nsf.timer >= period { // PLAY took too long, don't try to catch up
+			nsf.timer = 0
+		}
+		cpu.PushAddress(NSF_DRIVER - 1)
+		cpu.PC = nsf.PlayAddress
+	}
+}
+
+// Length of the track in ms
+func (nsf *NSF) TrackDuration(track int) int {
+	if track < len(nsf.TrackTimes) && nsf.TrackTimes[track] >= 0 {
+		return int(nsf.TrackTimes[track])
+	}
+	return NSF_DEFAULT_DURATION
+}
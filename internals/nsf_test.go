@@ -0,0 +1,179 @@
+package internals
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// INIT at $8000 starts a tone on pulse 1, PLAY at $8010 counts its calls in $00
+var nsfProgram = []byte{
+	0xA9, 0xBF, 0x8D, 0x00, 0x40, // LDA #$BF; STA $4000
+	0xA9, 0xFD, 0x8D, 0x02, 0x40, // LDA #$FD; STA $4002
+	0xA9, 0x00, 0x8D, 0x03, 0x40, // LDA #$00; STA $4003
+	0x60,       // RTS
+	0xE6, 0x00, // INC $00
+	0x60, // RTS
+}
+
+func nsfeChunk(id string, data []byte) []byte {
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], id)
+	return append(header, data...)
+}
+
+func writeNSF(t *testing.T, name string, data []byte) string {
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func runNSF(t *testing.T, filename string) *NES {
+	nes := NewNES()
+	if err := nes.LoadFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < CPU_FREQUENCY; i++ {
+		nes.Step()
+	}
+
+	// About 60 PLAY calls per second
+	if calls := nes.RAM[0]; calls < 59 || calls > 61 {
+		t.Error("Unexpected number of PLAY calls: ", calls)
+	}
+	if nes.APU.Pulse1.Length.Value == 0 || nes.APU.Pulse1.TimerPeriod != 0xFD {
+		t.Error("INIT did not start the tone")
+	}
+	return nes
+}
+
+func TestNSF(t *testing.T) {
+	header := make([]byte, 0x80)
+	copy(header, "NESM\x1A")
+	header[0x05] = 1
+	header[0x06] = 3 // Tracks
+	header[0x07] = 2 // Starting track
+	binary.LittleEndian.PutUint16(header[0x08:], 0x8000)
+	binary.LittleEndian.PutUint16(header[0x0A:], 0x8000)
+	binary.LittleEndian.PutUint16(header[0x0C:], 0x8010)
+	copy(header[0x0E:], "Title")
+	copy(header[0x2E:], "Artist")
+	binary.LittleEndian.PutUint16(header[0x6E:], NSF_DEFAULT_SPEED)
+
+	nes := runNSF(t, writeNSF(t, "test.nsf", append(header, nsfProgram...)))
+	if nes.NSF.Title != "Title" || nes.NSF.Artist != "Artist" || nes.NSF.Tracks != 3 || nes.NSF.Track != 1 {
+		t.Error("Invalid metadata: ", nes.NSF.Title, nes.NSF.Artist, nes.NSF.Tracks, nes.NSF.Track)
+	}
+}
+
+func TestNSFE(t *testing.T) {
+	info := []byte{0x00, 0x80, 0x00, 0x80, 0x10, 0x80, 0, 0, 2, 0}
+	time := make([]byte, 8)
+	binary.LittleEndian.PutUint32(time, 5000)
+	binary.LittleEndian.PutUint32(time[4:], 0xFFFFFFFF)
+
+	data := []byte("NSFE")
+	data = append(data, nsfeChunk("INFO", info)...)
+	data = append(data, nsfeChunk("DATA", nsfProgram)...)
+	data = append(data, nsfeChunk("auth", []byte("Title\x00Artist\x00\x00Ripper\x00"))...)
+	data = append(data, nsfeChunk("tlbl", []byte("First\x00Second\x00"))...)
+	data = append(data, nsfeChunk("time", time)...)
+	data = append(data, nsfeChunk("fade", []byte{0, 0, 0, 0})...)
+	data = append(data, nsfeChunk("NEND", nil)...)
+
+	nes := runNSF(t, writeNSF(t, "test.nsfe", data))
+	if nes.NSF.Ripper != "Ripper" || nes.NSF.TrackLabels[1] != "Second" || nes.NSF.Track != 0 {
+		t.Error("Invalid metadata: ", nes.NSF.Ripper, nes.NSF.TrackLabels, nes.NSF.Track)
+	}
+	if nes.NSF.TrackDuration(0) != 5000 || nes.NSF.TrackDuration(1) != NSF_DEFAULT_DURATION {
+		t.Error("Invalid track times: ", nes.NSF.TrackTimes)
+	}
+}
+
+func TestNSFStartingTrack(t *testing.T) {
+	header := make([]byte, 0x80)
+	copy(header, "NESM\x1A")
+	binary.LittleEndian.PutUint16(header[0x08:], 0x8000)
+	nsfe := func(info []byte) []byte {
+		data := append([]byte("NSFE"), nsfeChunk("INFO", info)...)
+		data = append(data, nsfeChunk("DATA", nsfProgram)...)
+		return append(data, nsfeChunk("NEND", nil)...)
+	}
+
+	tests := []struct {
+		name     string
+		tracks   uint8
+		starting uint8
+		expected int
+	}{
+		{"First", 3, 0, 0},
+		{"Last", 3, 2, 2},
+		{"Past the end", 3, 200, 2},
+		{"No tracks", 0, 1, 0},
+	}
+
+	for _, test := range tests {
+		// NSF counts from 1, NSFe from 0
+		header[0x06] = test.tracks
+		header[0x07] = test.starting + 1
+		nsf, err := parseNSF(append(header, nsfProgram...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nsf.StartingTrack != test.expected {
+			t.Errorf("%s: got track %d in NSF, expected %d", test.name, nsf.StartingTrack, test.expected)
+		}
+
+		nsf, err = parseNSF(nsfe([]byte{0x00, 0x80, 0x00, 0x80, 0x10, 0x80, 0, 0, test.tracks, test.starting}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nsf.StartingTrack != test.expected {
+			t.Errorf("%s: got track %d in NSFe, expected %d", test.name, nsf.StartingTrack, test.expected)
+		}
+	}
+
+	// A starting track of 0 in the NSF header
+	header[0x06] = 3
+	header[0x07] = 0
+	if nsf, err := parseNSF(append(header, nsfProgram...)); err != nil || nsf.StartingTrack != 0 {
+		t.Error("Starting track 0 not clamped: ", err)
+	}
+}
+
+func TestNSFPPU(t *testing.T) {
+	header := make([]byte, 0x80)
+	copy(header, "NESM\x1A")
+	header[0x06] = 1
+	header[0x07] = 1
+	binary.LittleEndian.PutUint16(header[0x08:], 0x8000)
+	binary.LittleEndian.PutUint16(header[0x0A:], 0x8000)
+	binary.LittleEndian.PutUint16(header[0x0C:], 0x8015)
+	// INIT writes to a name table and turns rendering on, PLAY reads the pattern tables
+	program := []byte{
+		0xA9, 0x20, 0x8D, 0x06, 0x20, // LDA #$20; STA $2006
+		0xA9, 0x00, 0x8D, 0x06, 0x20, // LDA #$00; STA $2006
+		0xA9, 0x42, 0x8D, 0x07, 0x20, // LDA #$42; STA $2007
+		0xA9, 0x1E, 0x8D, 0x01, 0x20, // LDA #$1E; STA $2001
+		0x60,                         // RTS
+		0xA9, 0x00, 0x8D, 0x06, 0x20, // LDA #$00; STA $2006
+		0x8D, 0x06, 0x20, // STA $2006
+		0xAD, 0x07, 0x20, // LDA $2007
+		0x60, // RTS
+	}
+
+	nes := NewNES()
+	if err := nes.LoadFile(writeNSF(t, "ppu.nsf", append(header, program...))); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < CPU_FREQUENCY/10; i++ {
+		nes.Step()
+	}
+	if nes.PPU.Nametables[0] != 0x42 {
+		t.Error("Name table not written: ", nes.PPU.Nametables[0])
+	}
+}
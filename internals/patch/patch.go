@@ -0,0 +1,308 @@
+// Package patch applies the IPS, UPS and BPS patches used by translations and romhacks
+package patch
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown patch format")
+	ErrCorrupt       = errors.New("corrupt patch")
+
+	// The patch was made for another file, or was already applied
+	ErrSourceChecksum = errors.New("the patch does not match the ROM")
+	ErrTargetChecksum = errors.New("the patched ROM has the wrong checksum")
+	ErrPatchChecksum  = errors.New("the patch has the wrong checksum")
+)
+
+// Larger than any NES ROM, a bigger target size can only come from a corrupt patch
+const MaxTargetSize = 16 * 1024 * 1024
+
+// Applies a patch to a copy of source. The format is detected from the patch header
+func Apply(source []byte, patch []byte) ([]byte, error) {
+	switch {
+	case hasPrefix(patch, "PATCH"):
+		return ApplyIPS(source, patch)
+	case hasPrefix(patch, "UPS1"):
+		return ApplyUPS(source, patch)
+	case hasPrefix(patch, "BPS1"):
+		return ApplyBPS(source, patch)
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+func hasPrefix(data []byte, prefix string) bool {
+	return len(data) >= len(prefix) && string(data[:len(prefix)]) == prefix
+}
+
+// https://zerosoft.zophar.net/ips.php
+func ApplyIPS(source []byte, patch []byte) ([]byte, error) {
+	if !hasPrefix(patch, "PATCH") {
+		return nil, ErrUnknownFormat
+	}
+	target := append([]byte{}, source...)
+
+	reader := reader{data: patch, pointer: 5}
+	for {
+		if hasPrefix(patch[reader.pointer:], "EOF") {
+			// Extension: the size of the patched file can follow EOF
+			if reader.remaining() >= 6 {
+				if size := reader.uint24(3); size < len(target) {
+					target = target[:size]
+				}
+			}
+			return target, nil
+		}
+
+		offset := reader.uint24(0)
+		size := reader.uint16()
+		var data []byte
+		if size == 0 { // Run-length encoded record
+			size = reader.uint16()
+			value := reader.bytes(1)
+			if reader.err == nil {
+				data = make([]byte, size)
+				for i := range data {
+					data[i] = value[0]
+				}
+			}
+		} else {
+			data = reader.bytes(size)
+		}
+		if reader.err != nil {
+			return nil, reader.err
+		}
+
+		for len(target) < offset+size {
+			target = append(target, 0)
+		}
+		copy(target[offset:], data)
+	}
+}
+
+// http://www.romhacking.net/documents/392/
+func ApplyUPS(source []byte, patch []byte) ([]byte, error) {
+	if !hasPrefix(patch, "UPS1") {
+		return nil, ErrUnknownFormat
+	}
+	if err := checkFooter(source, patch); err != nil {
+		return nil, err
+	}
+
+	reader := reader{data: patch[:len(patch)-12], pointer: 4}
+	sourceSize := reader.varint()
+	targetSize := reader.varint()
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	if sourceSize != len(source) {
+		return nil, fmt.Errorf("%w: expected %d bytes, the ROM has %d", ErrSourceChecksum, sourceSize, len(source))
+	}
+	if targetSize > MaxTargetSize {
+		return nil, fmt.Errorf("%w: target of %d bytes", ErrCorrupt, targetSize)
+	}
+
+	target := make([]byte, targetSize)
+	copy(target, source)
+
+	offset := 0
+	for reader.remaining() > 0 {
+		offset += reader.varint()
+		for reader.err == nil {
+			value := reader.bytes(1)
+			if reader.err != nil {
+				break
+			}
+			if value[0] == 0 {
+				offset++
+				break
+			}
+			if offset >= len(target) {
+				return nil, ErrCorrupt
+			}
+			target[offset] ^= value[0]
+			offset++
+		}
+		if reader.err != nil {
+			return nil, reader.err
+		}
+	}
+
+	return target, checkTarget(target, patch)
+}
+
+// https://github.com/blakesmith/rombp/blob/master/docs/bps_spec.md
+func ApplyBPS(source []byte, patch []byte) ([]byte, error) {
+	if !hasPrefix(patch, "BPS1") {
+		return nil, ErrUnknownFormat
+	}
+	if err := checkFooter(source, patch); err != nil {
+		return nil, err
+	}
+
+	reader := reader{data: patch[:len(patch)-12], pointer: 4}
+	sourceSize := reader.varint()
+	targetSize := reader.varint()
+	metadataSize := reader.varint()
+	reader.bytes(metadataSize)
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	if sourceSize != len(source) {
+		return nil, fmt.Errorf("%w: expected %d bytes, the ROM has %d", ErrSourceChecksum, sourceSize, len(source))
+	}
+	if targetSize > MaxTargetSize {
+		return nil, fmt.Errorf("%w: target of %d bytes", ErrCorrupt, targetSize)
+	}
+
+	target := make([]byte, targetSize)
+	output := 0
+	sourceOffset := 0
+	targetOffset := 0
+	for reader.remaining() > 0 {
+		data := reader.varint()
+		command := data & 3
+		length := data>>2 + 1
+		if reader.err != nil {
+			return nil, reader.err
+		}
+		if output+length > len(target) {
+			return nil, ErrCorrupt
+		}
+
+		switch command {
+		case 0: // SourceRead
+			if output+length > len(source) {
+				return nil, ErrCorrupt
+			}
+			copy(target[output:], source[output:output+length])
+		case 1: // TargetRead
+			data := reader.bytes(length)
+			if reader.err != nil {
+				return nil, reader.err
+			}
+			copy(target[output:], data)
+		case 2: // SourceCopy
+			sourceOffset += reader.signedVarint()
+			if reader.err != nil || sourceOffset < 0 || sourceOffset+length > len(source) {
+				return nil, ErrCorrupt
+			}
+			copy(target[output:], source[sourceOffset:sourceOffset+length])
+			sourceOffset += length
+		case 3: // TargetCopy, the ranges can overlap to repeat a pattern
+			targetOffset += reader.signedVarint()
+			if reader.err != nil || targetOffset < 0 || targetOffset >= output {
+				return nil, ErrCorrupt
+			}
+			for i := 0; i < length; i++ {
+				target[output+i] = target[targetOffset]
+				targetOffset++
+			}
+		}
+		output += length
+	}
+
+	return target, checkTarget(target, patch)
+}
+
+// UPS and BPS end with the CRC32 of the source, of the target and of the rest of the patch
+func checkFooter(source []byte, patch []byte) error {
+	if len(patch) < 16 {
+		return ErrCorrupt
+	}
+	footer := patch[len(patch)-12:]
+	if expected, actual := le32(footer[8:]), crc32.ChecksumIEEE(patch[:len(patch)-4]); expected != actual {
+		return fmt.Errorf("%w: expected %08X, got %08X", ErrPatchChecksum, expected, actual)
+	}
+	if expected, actual := le32(footer[0:]), crc32.ChecksumIEEE(source); expected != actual {
+		return fmt.Errorf("%w: expected a ROM with CRC32 %08X, got %08X", ErrSourceChecksum, expected, actual)
+	}
+	return nil
+}
+
+func checkTarget(target []byte, patch []byte) error {
+	if expected, actual := le32(patch[len(patch)-8:]), crc32.ChecksumIEEE(target); expected != actual {
+		return fmt.Errorf("%w: expected %08X, got %08X", ErrTargetChecksum, expected, actual)
+	}
+	return nil
+}
+
+func le32(data []byte) uint32 {
+	return uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16 | uint32(data[3])<<24
+}
+
+// Reads the patch, the first error is kept and every following read returns zero values
+type reader struct {
+	data    []byte
+	pointer int
+	err     error
+}
+
+func (reader *reader) remaining() int {
+	return len(reader.data) - reader.pointer
+}
+
+func (reader *reader) bytes(count int) []byte {
+	if reader.err != nil {
+		return nil
+	}
+	if count < 0 || count > reader.remaining() {
+		reader.err = ErrCorrupt
+		return nil
+	}
+	data := reader.data[reader.pointer : reader.pointer+count]
+	reader.pointer += count
+	return data
+}
+
+// Big endian, skipping the given number of bytes first
+func (reader *reader) uint24(skip int) int {
+	reader.bytes(skip)
+	data := reader.bytes(3)
+	if data == nil {
+		return 0
+	}
+	return int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+}
+
+func (reader *reader) uint16() int {
+	data := reader.bytes(2)
+	if data == nil {
+		return 0
+	}
+	return int(data[0])<<8 | int(data[1])
+}
+
+// Variable length number of UPS and BPS: 7 bits per byte, the last byte has the high bit set
+func (reader *reader) varint() int {
+	value := 0
+	shift := 1
+	for {
+		data := reader.bytes(1)
+		if data == nil {
+			return 0
+		}
+		value += int(data[0]&0x7F) * shift
+		if data[0]&0x80 != 0 {
+			return value
+		}
+		shift <<= 7
+		value += shift
+		if shift > 1<<48 {
+			reader.err = ErrCorrupt
+			return 0
+		}
+	}
+}
+
+// The lowest bit is the sign
+func (reader *reader) signedVarint() int {
+	value := reader.varint()
+	if value&1 != 0 {
+		return -(value >> 1)
+	}
+	return value >> 1
+}
@@ -0,0 +1,165 @@
+package patch
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+func encodeVarint(value int) []byte {
+	var data []byte
+	for {
+		x := byte(value & 0x7F)
+		value >>= 7
+		if value == 0 {
+			return append(data, 0x80|x)
+		}
+		data = append(data, x)
+		value--
+	}
+}
+
+func byteAt(data []byte, index int) byte {
+	if index < len(data) {
+		return data[index]
+	}
+	return 0
+}
+
+func appendCRC(data []byte, value uint32) []byte {
+	return append(data, byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+}
+
+// Adds the checksums at the end of an UPS or BPS patch
+func finishPatch(patch []byte, source []byte, target []byte) []byte {
+	patch = appendCRC(patch, crc32.ChecksumIEEE(source))
+	patch = appendCRC(patch, crc32.ChecksumIEEE(target))
+	return appendCRC(patch, crc32.ChecksumIEEE(patch))
+}
+
+var source = []byte("Hello, World! This is the original ROM.")
+
+func TestIPS(t *testing.T) {
+	patch := []byte("PATCH")
+	patch = append(patch, 0x00, 0x00, 0x07, 0x00, 0x05) // 5 bytes at 7
+	patch = append(patch, "Earth"...)
+	patch = append(patch, 0x00, 0x00, 0x28, 0x00, 0x00, 0x00, 0x03, '!') // RLE past the end
+	patch = append(patch, "EOF"...)
+
+	target, err := Apply(source, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Hello, Earth! This is the original ROM.\x00!!!"
+	if string(target) != expected {
+		t.Errorf("Wrong result: %q", target)
+	}
+	if string(source) != "Hello, World! This is the original ROM." {
+		t.Error("The source was modified")
+	}
+
+	truncated := append(append([]byte("PATCH"), "EOF"...), 0x00, 0x00, 0x05)
+	if target, err := Apply(source, truncated); err != nil || string(target) != "Hello" {
+		t.Errorf("Truncation not applied: %q %v", target, err)
+	}
+
+	if _, err := Apply(source, []byte("PATCH\x00\x00\x07\x00\x05Ear")); !errors.Is(err, ErrCorrupt) {
+		t.Error("Truncated record not detected: ", err)
+	}
+}
+
+func TestUPS(t *testing.T) {
+	target := []byte("Hello, Earth! This is the original ROM, patched.")
+
+	patch := []byte("UPS1")
+	patch = append(patch, encodeVarint(len(source))...)
+	patch = append(patch, encodeVarint(len(target))...)
+	skipped := 0
+	for i := 0; i < len(target); i++ {
+		if xor := target[i] ^ byteAt(source, i); xor == 0 {
+			skipped++
+			continue
+		}
+		// The record ends with a 0, which also skips the next byte
+		patch = append(patch, encodeVarint(skipped)...)
+		for ; i < len(target) && target[i]^byteAt(source, i) != 0; i++ {
+			patch = append(patch, target[i]^byteAt(source, i))
+		}
+		patch = append(patch, 0)
+		skipped = 0
+	}
+	patch = finishPatch(patch, source, target)
+
+	result, err := Apply(source, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, target) {
+		t.Errorf("Wrong result: %q", result)
+	}
+
+	if _, err := Apply([]byte("Another ROM"), patch); !errors.Is(err, ErrSourceChecksum) {
+		t.Error("Wrong source not detected: ", err)
+	}
+	patch[6] ^= 1
+	if _, err := Apply(source, patch); !errors.Is(err, ErrPatchChecksum) {
+		t.Error("Corrupt patch not detected: ", err)
+	}
+
+	huge := []byte("UPS1")
+	huge = append(huge, encodeVarint(len(source))...)
+	huge = append(huge, encodeVarint(1<<40)...)
+	huge = finishPatch(huge, source, nil)
+	if _, err := Apply(source, huge); !errors.Is(err, ErrCorrupt) {
+		t.Error("Huge target size not rejected: ", err)
+	}
+}
+
+func TestBPS(t *testing.T) {
+	target := []byte("Hello, Hello, Hello! This is the original ROM")
+
+	action := func(command int, length int) []byte {
+		return encodeVarint((length-1)<<2 | command)
+	}
+	patch := []byte("BPS1")
+	patch = append(patch, encodeVarint(len(source))...)
+	patch = append(patch, encodeVarint(len(target))...)
+	patch = append(patch, encodeVarint(4)...)
+	patch = append(patch, "meta"...)
+	patch = append(patch, action(0, 7)...)  // "Hello, "
+	patch = append(patch, action(3, 12)...) // "Hello, Hello"
+	patch = append(patch, encodeVarint(0)...)
+	patch = append(patch, action(1, 1)...) // "!"
+	patch = append(patch, '!')
+	patch = append(patch, action(2, 25)...) // " This is the original ROM"
+	patch = append(patch, encodeVarint(13<<1)...)
+	patch = finishPatch(patch, source, target)
+
+	result, err := Apply(source, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, target) {
+		t.Errorf("Wrong result: %q", result)
+	}
+
+	if _, err := Apply(source[1:], patch); !errors.Is(err, ErrSourceChecksum) {
+		t.Error("Wrong source not detected: ", err)
+	}
+
+	huge := []byte("BPS1")
+	huge = append(huge, encodeVarint(len(source))...)
+	huge = append(huge, encodeVarint(1<<40)...)
+	huge = append(huge, encodeVarint(0)...)
+	huge = finishPatch(huge, source, nil)
+	if _, err := Apply(source, huge); !errors.Is(err, ErrCorrupt) {
+		t.Error("Huge target size not rejected: ", err)
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := Apply(source, []byte("NES\x1A")); !errors.Is(err, ErrUnknownFormat) {
+		t.Error("Unknown format not detected: ", err)
+	}
+}
@@ -15,7 +15,7 @@ type PPU struct {
 	FrameCount uint64
 	Line       uint64
 
-	Nametables     [2 * 0x400]uint8
+	Nametables     [4 * 0x400]uint8 // The last 2 KB are the cartridge VRAM used by four-screen boards
 	PaletteStorage [0x20]uint8
 	OAMData        [256]uint8 // 64 entries of 4 bytes: y, tile, attributes, x; in this order
 	OAMAddr        uint8
@@ -134,10 +134,6 @@ func (ppu *PPU) incementPPUAddr() {
 
 func (ppu *PPU) ReadRegister(address uint16) uint8 {
 	switch address {
-	case 0x2000:
-		panic("PPUCTRL register is write only")
-	case 0x2001:
-		panic("PPUMASK register is write only")
 	case 0x2002:
 		var value uint8 = 0
 		if ppu.Registers.PPUSTATUS.SpriteOverflow {
@@ -155,14 +151,8 @@ func (ppu *PPU) ReadRegister(address uint16) uint8 {
 			ppu.NMI_Delay = 15
 		}
 		return value
-	case 0x2003:
-		panic("OAMADDR register is write only")
 	case 0x2004:
 		return ppu.OAMData[ppu.OAMAddr]
-	case 0x2005:
-		panic("PPUSCROLL register is write only")
-	case 0x2006:
-		panic("PPUADDR register is write only")
 	case 0x2007:
 		buffered := ppu.ReadData
 		_ = ppu.Read(ppu.PPUAddr)
@@ -171,29 +161,21 @@ func (ppu *PPU) ReadRegister(address uint16) uint8 {
 			return ppu.ReadData
 		}
 		return buffered
-	case 0x4014:
-		panic("OAMDMA register is write only")
 	}
+	// The other registers are write only, the open bus is not emulated
 	return 0
 }
 
 func (ppu *PPU) Read(address uint16) uint8 {
+	if address < 0x3F00 { // Palette reads don't reach the bus
+		ppu.Bus.nes.Cartridge.Mapper.PPUAddress(address)
+	}
 	switch {
 	case address < 0x2000: // pattern tables, on the cartridge
-		ppu.ReadData = ppu.Bus.nes.Cartridge.CHR_ROM[address]
+		ppu.ReadData = ppu.Bus.nes.Cartridge.Read(address)
 		return ppu.ReadData
 	case address < 0x3F00: // name tables
-		address = address % 0x1000
-		if ppu.Bus.nes.Cartridge.Header.Mirroring {
-			address = address % 0x800
-		} else {
-			if address < 0x800 {
-				address = address % 0x400
-			} else {
-				address = (address % 0x400) + 0x400
-			}
-		}
-		ppu.ReadData = ppu.Nametables[address%0x800]
+		ppu.ReadData = ppu.Nametables[ppu.mirrorAddress(address)]
 		return ppu.ReadData
 	case address < 0x4000: // palette
 		if address > 0x3F0F && address%0x4 == 0 {
@@ -207,6 +189,22 @@ func (ppu *PPU) Read(address uint16) uint8 {
 	}
 }
 
+// Returns the index in Nametables for a name table address
+// https://wiki.nesdev.org/w/index.php?title=Mirroring
+func (ppu *PPU) mirrorAddress(address uint16) uint16 {
+	address = address % 0x1000
+	quadrant := address / 0x400
+
+	var nametable uint8
+	mapper := ppu.Bus.nes.Cartridge.Mapper
+	if custom, ok := mapper.(NametableMapper); ok {
+		nametable = custom.Nametable(uint8(quadrant))
+	} else {
+		nametable = NAMETABLE_LAYOUTS[mapper.Mirroring()][quadrant]
+	}
+	return uint16(nametable)*0x400 + address%0x400
+}
+
 func (ppu *PPU) WriteRegister(address uint16, value uint8) {
 	switch address {
 	case 0x2000:
@@ -265,40 +263,20 @@ func (ppu *PPU) WriteRegister(address uint16, value uint8) {
 	case 0x2007:
 		ppu.Write(ppu.PPUAddr, value)
 		ppu.incementPPUAddr()
-	case 0x4014:
-		page := value
-		starting_address := uint16(page) << 8
-
-		for i := 0; i < 256; i++ {
-			ppu.OAMData[i] = ppu.Bus.Read(starting_address + uint16(i))
-		}
-
-		ppu.Bus.nes.CPU.CycleCount += 513
-		if ppu.Bus.nes.CPU.CycleCount%2 == 1 {
-			ppu.Bus.nes.CPU.CycleCount++
-		}
-		ppu.CycleCount += 171
 	default:
 		panic("Invalid PPU register")
 	}
 }
 
 func (ppu *PPU) Write(address uint16, value uint8) {
+	if address < 0x3F00 {
+		ppu.Bus.nes.Cartridge.Mapper.PPUAddress(address)
+	}
 	switch {
 	case address < 0x2000: // pattern tables, on the cartridge
 		ppu.Bus.nes.Cartridge.Write(address, value)
 	case address < 0x3F00: // name tables
-		address = address % 0x1000
-		if ppu.Bus.nes.Cartridge.Header.Mirroring {
-			address = address % 0x800
-		} else {
-			if address < 0x800 {
-				address = address % 0x400
-			} else {
-				address = (address % 0x400) + 0x400
-			}
-		}
-		ppu.Nametables[address] = value
+		ppu.Nametables[ppu.mirrorAddress(address)] = value
 	case address < 0x4000: // palette
 		if address > 0x3F0F && address%0x4 == 0 {
 			ppu.PaletteStorage[address-0x3F10] = value
@@ -418,6 +396,20 @@ func (ppu *PPU) evaluateSprites() {
 		ppu.Registers.PPUSTATUS.SpriteOverflow = true
 	}
 	ppu.Sprites.Count = uint8(count)
+	ppu.fetchEmptySprites(count)
+}
+
+// The unused sprite slots still fetch the pattern of tile $FF. Mappers like the MMC3 count scanlines
+// with these fetches
+func (ppu *PPU) fetchEmptySprites(count int) {
+	address := ppu.Registers.PPUCTRL.SpritePatternTableBase + 0xFF*16
+	if ppu.Registers.PPUCTRL.SpriteSize {
+		address = 0x1000 + 0xFE*16
+	}
+	for i := count; i < 8; i++ {
+		ppu.Read(address)
+		ppu.Read(address + 8)
+	}
 }
 
 func (ppu *PPU) renderPixel() {
@@ -578,12 +570,19 @@ func (ppu *PPU) Cycle() {
 
 	}
 
+	if renderingEnabled && renderLine && ppu.CycleCount == 260 {
+		ppu.Bus.nes.Cartridge.Mapper.Scanline()
+	}
+
 	if renderingEnabled {
 		if ppu.CycleCount == 257 {
 			if visibleLine {
 				ppu.evaluateSprites()
 			} else {
 				ppu.Sprites.Count = 0
+				if preLine {
+					ppu.fetchEmptySprites(0)
+				}
 			}
 		}
 	}
@@ -0,0 +1,84 @@
+package internals
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrRingBufferClosed = errors.New("ring buffer closed")
+
+// Audio sink that blocks the emulator while it is full. The samples are consumed by the audio
+// device at its own rate, so the speed of the emulation follows the sound card
+type RingBuffer struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	samples []float32
+	start   int
+	count   int
+	rate    int
+	closed  bool
+}
+
+func NewRingBuffer(sampleRate int, size int) *RingBuffer {
+	ring := &RingBuffer{samples: make([]float32, size), rate: sampleRate}
+	ring.cond = sync.NewCond(&ring.mutex)
+	return ring
+}
+
+func (ring *RingBuffer) SampleRate() int {
+	return ring.rate
+}
+
+// Blocks until all the samples fit in the buffer or the buffer is closed
+func (ring *RingBuffer) WriteSamples(samples []float32) error {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+
+	for len(samples) > 0 {
+		for ring.count == len(ring.samples) && !ring.closed {
+			ring.cond.Wait()
+		}
+		if ring.closed {
+			return ErrRingBufferClosed
+		}
+		for len(samples) > 0 && ring.count < len(ring.samples) {
+			ring.samples[(ring.start+ring.count)%len(ring.samples)] = samples[0]
+			ring.count++
+			samples = samples[1:]
+		}
+	}
+	return nil
+}
+
+// Does not block. Returns the number of samples read, which is lower than len(samples) on an underrun
+func (ring *RingBuffer) ReadSamples(samples []float32) int {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+
+	count := 0
+	for count < len(samples) && ring.count > 0 {
+		samples[count] = ring.samples[ring.start]
+		ring.start = (ring.start + 1) % len(ring.samples)
+		ring.count--
+		count++
+	}
+	if count > 0 {
+		ring.cond.Broadcast()
+	}
+	return count
+}
+
+// Returns how full the buffer is, between 0 and 1
+func (ring *RingBuffer) Fill() float64 {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	return float64(ring.count) / float64(len(ring.samples))
+}
+
+// Wakes up the blocked writers, every following write fails
+func (ring *RingBuffer) Close() {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	ring.closed = true
+	ring.cond.Broadcast()
+}
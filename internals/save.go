@@ -0,0 +1,128 @@
+package internals
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Keeps the battery-backed RAM of the cartridges between sessions
+type SaveStorage interface {
+	// Returns an error matching fs.ErrNotExist when there is no save yet
+	Load(name string) ([]byte, error)
+	Save(name string, data []byte) error
+}
+
+// Stores the saves as files, the name is the path of the file
+type FileStorage struct{}
+
+func (storage FileStorage) Load(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// Writes a temporary file then renames it, so a crash never leaves a half written save
+func (storage FileStorage) Save(name string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // Fails once renamed
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), name)
+}
+
+// Keeps the saves in memory, used by the tests
+type MemoryStorage struct {
+	mutex sync.Mutex
+	Files map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{Files: map[string][]byte{}}
+}
+
+func (storage *MemoryStorage) Load(name string) ([]byte, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	data, ok := storage.Files[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return append([]byte{}, data...), nil
+}
+
+func (storage *MemoryStorage) Save(name string, data []byte) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.Files[name] = append([]byte{}, data...)
+	return nil
+}
+
+// Name of the save of a ROM file: the same path with the .sav extension
+func SaveName(filename string) string {
+	return filename[:len(filename)-len(filepath.Ext(filename))] + ".sav"
+}
+
+// The FDS saves the whole disk image, the original file is left untouched
+func (nes *NES) hasBattery() bool {
+	persistent := nes.FDS != nil || nes.Cartridge.Header.PersistentRAM
+	return nes.Cartridge.Loaded && persistent && nes.Storage != nil && nes.SaveName != ""
+}
+
+// Restores the PRG-RAM of cartridges with a battery, or the modified disk image. A missing save
+// is not an error
+func (nes *NES) LoadBattery() error {
+	if !nes.hasBattery() {
+		return nil
+	}
+	data, err := nes.Storage.Load(nes.SaveName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if nes.FDS != nil {
+		return nes.FDS.loadImage(data)
+	}
+	copy(nes.Cartridge.RAM[:], data)
+	nes.savedRAM = nes.Cartridge.RAM
+	return nil
+}
+
+// Writes the PRG-RAM of cartridges with a battery or the disk image, if it changed since the last save
+func (nes *NES) SaveBattery() error {
+	if nes.FDS != nil && nes.hasBattery() {
+		if !nes.FDS.Modified {
+			return nil
+		}
+		if err := nes.Storage.Save(nes.SaveName, nes.FDS.Image()); err != nil {
+			return err
+		}
+		nes.FDS.Modified = false
+		return nil
+	}
+	if !nes.hasBattery() || bytes.Equal(nes.savedRAM[:], nes.Cartridge.RAM[:]) {
+		return nil
+	}
+	ram := nes.Cartridge.RAM
+	if err := nes.Storage.Save(nes.SaveName, ram[:]); err != nil {
+		return err
+	}
+	nes.savedRAM = ram
+	return nil
+}
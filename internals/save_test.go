@@ -0,0 +1,70 @@
+package internals
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatterySave(t *testing.T) {
+	storage := NewMemoryStorage()
+	nes := newTestNES(0, 32*1024, 8*1024)
+	nes.Cartridge.Loaded = true
+	nes.Cartridge.Header.PersistentRAM = true
+	nes.Storage = storage
+	nes.SaveName = "game.sav"
+
+	if err := nes.LoadBattery(); err != nil {
+		t.Fatal("A missing save should not be an error: ", err)
+	}
+	if err := nes.SaveBattery(); err != nil || len(storage.Files) != 0 {
+		t.Fatal("Unchanged RAM saved")
+	}
+
+	nes.Bus.Write(0x6000, 0x42)
+	if err := nes.SaveBattery(); err != nil {
+		t.Fatal(err)
+	}
+	if data := storage.Files["game.sav"]; len(data) != 0x2000 || data[0] != 0x42 {
+		t.Fatal("RAM not saved")
+	}
+
+	other := newTestNES(0, 32*1024, 8*1024)
+	other.Cartridge.Loaded = true
+	other.Cartridge.Header.PersistentRAM = true
+	other.Storage = storage
+	other.SaveName = "game.sav"
+	if err := other.LoadBattery(); err != nil {
+		t.Fatal(err)
+	}
+	if other.Bus.Read(0x6000) != 0x42 {
+		t.Error("RAM not restored")
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "game.sav")
+	storage := FileStorage{}
+	if _, err := storage.Load(name); !os.IsNotExist(err) {
+		t.Error("Expected a missing file: ", err)
+	}
+	if err := storage.Save(name, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Save(name, []byte{4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := storage.Load(name)
+	if err != nil || string(data) != "\x04\x05" {
+		t.Error("Wrong save contents: ", data, err)
+	}
+
+	files, _ := os.ReadDir(filepath.Dir(name))
+	if len(files) != 1 {
+		t.Error("Temporary files left behind: ", len(files))
+	}
+
+	if SaveName("/roms/Zelda.nes") != "/roms/Zelda.sav" {
+		t.Error("Wrong save name")
+	}
+}
@@ -0,0 +1,124 @@
+package internals
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// https://wiki.nesdev.org/w/index.php?title=UNIF
+
+// Returned when the board of a UNIF file has no known mapper
+type ErrUnsupportedBoard struct {
+	Board string
+}
+
+func (err ErrUnsupportedBoard) Error() string {
+	return "unsupported board: " + err.Board
+}
+
+// Mapper of the boards, without the NES-, HVC- or UNL- prefix
+var UNIF_BOARDS = map[string]uint{
+	"NROM": 0, "NROM-128": 0, "NROM-256": 0, "RROM": 0, "RROM-128": 0,
+
+	"SAROM": 1, "SBROM": 1, "SCROM": 1, "SC1ROM": 1, "SEROM": 1, "SFROM": 1, "SGROM": 1, "SHROM": 1,
+	"SH1ROM": 1, "SJROM": 1, "SKROM": 1, "SLROM": 1, "SL1ROM": 1, "SL2ROM": 1, "SL3ROM": 1,
+	"SLRROM": 1, "SNROM": 1, "SOROM": 1, "SUROM": 1, "SXROM": 1,
+
+	"UNROM": 2, "UOROM": 2,
+
+	"CNROM": 3,
+
+	"TBROM": 4, "TEROM": 4, "TFROM": 4, "TGROM": 4, "TKROM": 4, "TLROM": 4, "TL1ROM": 4, "TL2ROM": 4,
+	"TNROM": 4, "TR1ROM": 4, "TSROM": 4, "TVROM": 4, "B4": 4,
+
+	"AMROM": 7, "ANROM": 7, "AN1ROM": 7, "AOROM": 7,
+
+	"COLORDREAMS": 11,
+
+	"GNROM": 66, "MHROM": 66,
+}
+
+func isUNIF(data []byte) bool {
+	return len(data) >= 4 && string(data[0:4]) == "UNIF"
+}
+
+func unifMapper(board string) (uint, bool) {
+	for _, prefix := range []string{"NES-", "HVC-", "UNL-", "BTL-", "BMC-"} {
+		board = strings.TrimPrefix(board, prefix)
+	}
+	mapper, ok := UNIF_BOARDS[board]
+	return mapper, ok
+}
+
+// Reads the chunks of a UNIF file, filling the header of the cartridge. Returns the PRG and CHR data
+func parseUNIF(header *Header, data []byte) ([]byte, []byte, error) {
+	if len(data) < 32 {
+		return nil, nil, fmt.Errorf("%w: UNIF header", ErrTruncated)
+	}
+
+	var board string
+	var prgChunks, chrChunks [16][]byte
+	pointer := 32
+	for pointer < len(data) {
+		if pointer+8 > len(data) {
+			return nil, nil, fmt.Errorf("%w: UNIF chunk header", ErrTruncated)
+		}
+		id := string(data[pointer : pointer+4])
+		size := int(binary.LittleEndian.Uint32(data[pointer+4:]))
+		pointer += 8
+		if size < 0 || size > len(data)-pointer {
+			return nil, nil, fmt.Errorf("%w: UNIF %s chunk", ErrTruncated, id)
+		}
+		chunk := data[pointer : pointer+size]
+		pointer += size
+
+		switch {
+		case id == "MAPR":
+			board = nsfString(chunk)
+		case id == "MIRR" && len(chunk) > 0:
+			// Single-screen (2, 3) and mapper controlled (5) are decided by the mapper
+			header.Mirroring = chunk[0] == 1
+			header.IgnoreMorriring = chunk[0] == 4
+		case id == "BATR":
+			header.PersistentRAM = true
+		case strings.HasPrefix(id, "PRG") || strings.HasPrefix(id, "CHR"):
+			index, err := parseHexDigit(id[3])
+			if err != nil {
+				continue // Not a ROM chunk, e.g. PCK0
+			}
+			if id[0] == 'P' {
+				prgChunks[index] = chunk
+			} else {
+				chrChunks[index] = chunk
+			}
+		}
+	}
+
+	mapper, ok := unifMapper(board)
+	if !ok {
+		return nil, nil, ErrUnsupportedBoard{board}
+	}
+	header.Mapper = mapper
+
+	var prg, chr []byte
+	for i := range prgChunks {
+		prg = append(prg, prgChunks[i]...)
+		chr = append(chr, chrChunks[i]...)
+	}
+	if len(prg) == 0 {
+		return nil, nil, ErrNoPRGROM
+	}
+	return prg, chr, nil
+}
+
+func parseHexDigit(digit byte) (int, error) {
+	switch {
+	case digit >= '0' && digit <= '9':
+		return int(digit - '0'), nil
+	case digit >= 'A' && digit <= 'F':
+		return int(digit-'A') + 10, nil
+	default:
+		return 0, fmt.Errorf("invalid hexadecimal digit %c", digit)
+	}
+}
@@ -0,0 +1,51 @@
+package internals
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func unifChunk(id string, data []byte) []byte {
+	chunk := append([]byte(id), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+	return append(chunk, data...)
+}
+
+func TestUNIF(t *testing.T) {
+	prg0 := bytes.Repeat([]byte{0x11}, 64*1024)
+	prg1 := bytes.Repeat([]byte{0x22}, 64*1024)
+
+	file := append([]byte("UNIF"), make([]byte, 28)...)
+	file[4] = 7
+	file = append(file, unifChunk("MAPR", []byte("NES-SNROM\x00"))...)
+	file = append(file, unifChunk("NAME", []byte("Test\x00"))...)
+	file = append(file, unifChunk("PRG1", prg1)...)
+	file = append(file, unifChunk("PRG0", prg0)...)
+	file = append(file, unifChunk("MIRR", []byte{1})...)
+	file = append(file, unifChunk("BATR", []byte{1})...)
+
+	nes := NewNES()
+	if err := nes.LoadROM(bytes.NewReader(file)); err != nil {
+		t.Fatal(err)
+	}
+	header := nes.Cartridge.Header
+	if header.Mapper != 1 || !header.Mirroring || !header.PersistentRAM || header.PRG_ROM_size != 128*1024 {
+		t.Errorf("Wrong header: %+v", header)
+	}
+	if !nes.Cartridge.CHR_RAM {
+		t.Error("CHR-RAM not allocated")
+	}
+	// The PRG chunks are ordered by their number, the last bank is fixed at 0xC000 on power up
+	if nes.Bus.Read(0x8000) != 0x11 || nes.Bus.Read(0xC000) != 0x22 {
+		t.Error("Wrong PRG-ROM")
+	}
+
+	unknown := append([]byte("UNIF"), make([]byte, 28)...)
+	unknown = append(unknown, unifChunk("MAPR", []byte("UNL-UNKNOWN\x00"))...)
+	var board ErrUnsupportedBoard
+	if err := NewNES().LoadROM(bytes.NewReader(unknown)); !errors.As(err, &board) || board.Board != "UNL-UNKNOWN" {
+		t.Error("Unknown board not reported: ", err)
+	}
+}
@@ -0,0 +1,93 @@
+package internals
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+)
+
+// Writes the audio to a 16 bit mono PCM WAV file
+// http://soundfile.sapp.org/doc/WaveFormat/
+type WAVSink struct {
+	file     *os.File
+	writer   *bufio.Writer
+	rate     int
+	dataSize uint32
+}
+
+func NewWAVSink(filename string, sampleRate int) (*WAVSink, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &WAVSink{file: file, writer: bufio.NewWriter(file), rate: sampleRate}
+	// The sizes are filled in when the file is closed
+	if err := sink.writeHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (sink *WAVSink) writeHeader() error {
+	var header [44]byte
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], 36+sink.dataSize)
+	copy(header[8:], "WAVE")
+	copy(header[12:], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)                  // Size of the fmt chunk
+	binary.LittleEndian.PutUint16(header[20:], 1)                   // PCM
+	binary.LittleEndian.PutUint16(header[22:], 1)                   // Mono
+	binary.LittleEndian.PutUint32(header[24:], uint32(sink.rate))   // Sample rate
+	binary.LittleEndian.PutUint32(header[28:], uint32(sink.rate)*2) // Byte rate
+	binary.LittleEndian.PutUint16(header[32:], 2)                   // Block align
+	binary.LittleEndian.PutUint16(header[34:], 16)                  // Bits per sample
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], sink.dataSize)
+	_, err := sink.writer.Write(header[:])
+	return err
+}
+
+func (sink *WAVSink) SampleRate() int {
+	return sink.rate
+}
+
+func (sink *WAVSink) WriteSamples(samples []float32) error {
+	var buffer [2]byte
+	for _, sample := range samples {
+		if sample > 1 {
+			sample = 1
+		} else if sample < -1 {
+			sample = -1
+		}
+		binary.LittleEndian.PutUint16(buffer[:], uint16(int16(sample*32767)))
+		if _, err := sink.writer.Write(buffer[:]); err != nil {
+			return err
+		}
+	}
+	sink.dataSize += uint32(len(samples) * 2)
+	return nil
+}
+
+// Writes the final sizes in the header and closes the file
+func (sink *WAVSink) Close() error {
+	if err := sink.writer.Flush(); err != nil {
+		sink.file.Close()
+		return err
+	}
+	if _, err := sink.file.Seek(0, 0); err != nil {
+		sink.file.Close()
+		return err
+	}
+	sink.writer.Reset(sink.file)
+	if err := sink.writeHeader(); err != nil {
+		sink.file.Close()
+		return err
+	}
+	if err := sink.writer.Flush(); err != nil {
+		sink.file.Close()
+		return err
+	}
+	return sink.file.Close()
+}
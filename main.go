@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -19,7 +20,13 @@ import (
 )
 
 const (
-	FREQUENCY = 1789773
+	FREQUENCY = internals.CPU_FREQUENCY
+
+	// The audio ring buffer holds 1/20 of a second
+	AUDIO_LATENCY_DIVIDER = 20
+
+	// The battery-backed RAM is written every 5 seconds, when it changed
+	SAVE_INTERVAL = 300 // frames
 )
 
 const (
@@ -152,15 +159,53 @@ var ROMFile = flag.String("file", "", "ROM file to load")
 var PPUViewer = flag.Bool("ppu", false, "Show PPU viewer")
 var Palette = flag.String("palette", "00,12,24,2A", "Palette information to use. Must be 4 hexadecimal representation of colors separated by commas (0x00-0x3F)")
 var Config = flag.String("config", "", "Configuration file for the emulator containing the keyboard mapping")
+var WAVFile = flag.String("wav", "", "Write the audio output to a WAV file")
+var SampleRate = flag.Int("rate", 44100, "Audio sample rate in Hz (44100 or 48000)")
+var Frames = flag.Int("frames", 0, "Run without a window for the given number of frames, then exit")
+var Stems = flag.String("stems", "", "Write the output of every audio channel to its own WAV file, named <stems>_<channel>.wav")
+var NSFMode = flag.Bool("nsf", false, "Play an NSF or NSFe music file without a window and show the track information")
+var Track = flag.Int("track", 0, "NSF track to play, starting from 1. By default every track is played, starting with the default one")
+var PatchFiles patchList
+
+// Value of a flag that can be repeated
+type patchList []string
+
+func (list *patchList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *patchList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+var BIOSFile = flag.String("bios", "disksys.rom", "FDS BIOS image, needed to play disk images")
+var Entry = flag.String("entry", "", "File to load from a .zip archive, by default the first .nes, .fds or .nsf file")
+var Info = flag.Bool("info", false, "Print the game database entry of the ROM and exit")
+var Pacing = flag.String("pacing", "free", "What sets the speed of the emulation: free (wall clock), vsync (display refresh) or timer (the samples are consumed on a timer, no sound is played)")
 
 var cpuprofile = ""
 
 var USER_INPUT struct {
-	A, B, Select, Start, Up, Down, Left, Right, Reset glfw.Key
+	A, B, Select, Start, Up, Down, Left, Right, Reset, Disk glfw.Key
 }
 
+var AUDIO_CHANNELS [internals.CHANNEL_COUNT]internals.ChannelControl
+var MUTE_KEYS [internals.CHANNEL_COUNT]glfw.Key
+var muteKeysDown [internals.CHANNEL_COUNT]bool
+var diskKeyDown bool
+var haltReported bool
+
 type ConfigS struct {
-	Keys ConfigKeys `json:"keys"`
+	Keys  ConfigKeys               `json:"keys"`
+	Audio map[string]ConfigChannel `json:"audio"` // Indexed by the channel name
+}
+
+type ConfigChannel struct {
+	Mute   bool     `json:"mute"`
+	Solo   bool     `json:"solo"`
+	Volume *float32 `json:"volume"`
+	Toggle string   `json:"toggle"` // Key that mutes and unmutes the channel
 }
 
 type ConfigKeys struct {
@@ -173,6 +218,7 @@ type ConfigKeys struct {
 	Select string `json:"select"`
 	Start  string `json:"start"`
 	Reset  string `json:"reset"`
+	Disk   string `json:"disk"` // Switches the side of the FDS disk
 }
 
 func getKeyCode(key string) (glfw.Key, error) {
@@ -180,7 +226,7 @@ func getKeyCode(key string) (glfw.Key, error) {
 	if character >= 'a' && character <= 'z' {
 		character = character - 'a' + 'A'
 	}
-	if character >= 'A' && character <= 'Z' {
+	if (character >= 'A' && character <= 'Z') || (character >= '0' && character <= '9') {
 		return glfw.Key(character), nil
 	} else {
 		return glfw.Key(0), fmt.Errorf("invalid key %s", key)
@@ -197,6 +243,12 @@ func loadConfig() {
 	USER_INPUT.Left = glfw.KeyA
 	USER_INPUT.Right = glfw.KeyD
 	USER_INPUT.Reset = glfw.KeyR
+	USER_INPUT.Disk = glfw.KeyF
+
+	for channel := range AUDIO_CHANNELS {
+		AUDIO_CHANNELS[channel] = internals.ChannelControl{Volume: 1}
+		MUTE_KEYS[channel] = glfw.KeyUnknown
+	}
 
 	if *Config != "" {
 		configData, err := ioutil.ReadFile(*Config)
@@ -287,9 +339,48 @@ func loadConfig() {
 				USER_INPUT.Reset = key
 			}
 		}
+
+		if config.Keys.Disk != "" {
+			key, err := getKeyCode(config.Keys.Disk)
+			if err != nil {
+				log.Println("Invalid key for Disk:", config.Keys.Disk)
+			} else {
+				USER_INPUT.Disk = key
+			}
+		}
+
+		for name, channelConfig := range config.Audio {
+			channel := channelIndex(name)
+			if channel < 0 {
+				log.Println("Invalid audio channel:", name)
+				continue
+			}
+			AUDIO_CHANNELS[channel].Mute = channelConfig.Mute
+			AUDIO_CHANNELS[channel].Solo = channelConfig.Solo
+			if channelConfig.Volume != nil {
+				AUDIO_CHANNELS[channel].Volume = *channelConfig.Volume
+			}
+			if channelConfig.Toggle != "" {
+				key, err := getKeyCode(channelConfig.Toggle)
+				if err != nil {
+					log.Println("Invalid toggle key for "+name+":", channelConfig.Toggle)
+				} else {
+					MUTE_KEYS[channel] = key
+				}
+			}
+		}
 	}
 }
 
+func channelIndex(name string) int {
+	for channel, channelName := range internals.CHANNEL_NAMES {
+		if channelName == name {
+			return channel
+		}
+	}
+	return -1
+}
+
 // 0,16,27,18
 func main() {
 	if cpuprofile != "" {
@@ -306,6 +397,7 @@ func main() {
 	flag.BoolVar(PPUViewer, "p", false, "alias for `ppu`")
 	flag.StringVar(Palette, "l", "00,12,24,2A", "alias for `palette`")
 	flag.StringVar(Config, "c", "", "alias for `config`")
+	flag.Var(&PatchFiles, "patch", "IPS, UPS or BPS patch to apply to the ROM, can be repeated. By default a patch with the name of the ROM is used")
 
 	flag.Parse()
 
@@ -314,8 +406,76 @@ func main() {
 		return
 	}
 
+	if *Info {
+		printInfo(*ROMFile)
+		return
+	}
+
 	loadConfig()
 
+	nes := internals.NewNES()
+	nes.Patches = PatchFiles
+	nes.ArchiveEntry = *Entry
+	if bios, err := os.ReadFile(*BIOSFile); err == nil {
+		nes.FDSBIOS = bios
+	}
+	if err := nes.LoadFile(*ROMFile); err != nil {
+		log.Fatal("Could not load the file: ", err)
+	}
+	defer saveBattery(nes)
+	nes.APU.Channels = AUDIO_CHANNELS
+
+	if *Pacing != "free" && *Pacing != "vsync" && *Pacing != "timer" {
+		log.Fatal("Invalid pacing. Must be free, vsync or timer. Provided value: ", *Pacing)
+	}
+
+	var wav *internals.WAVSink
+	var output internals.AudioSink
+	if *WAVFile != "" {
+		var err error
+		wav, err = internals.NewWAVSink(*WAVFile, *SampleRate)
+		if err != nil {
+			log.Fatal("Could not create the WAV file: ", err)
+		}
+		output = wav
+	}
+
+	var device *timerSink
+	if *Pacing == "free" || *Frames > 0 {
+		if output != nil {
+			nes.SetAudioSink(output)
+		}
+	} else {
+		ring := internals.NewRingBuffer(*SampleRate, *SampleRate/AUDIO_LATENCY_DIVIDER)
+		nes.SetAudioSink(ring)
+		nes.Audio.RateControl = *Pacing == "vsync"
+		device = startTimerSink(ring, output)
+	}
+	defer closeAudio(nes, device, wav)
+
+	if *Stems != "" {
+		var stems [internals.CHANNEL_COUNT]*internals.WAVSink
+		for channel, name := range internals.CHANNEL_NAMES {
+			stem, err := internals.NewWAVSink(*Stems+"_"+name+".wav", *SampleRate)
+			if err != nil {
+				log.Fatal("Could not create the WAV file: ", err)
+			}
+			stems[channel] = stem
+			nes.SetStemSink(channel, stem)
+		}
+		defer closeStems(nes, stems)
+	}
+
+	if *NSFMode || nes.NSF != nil {
+		playNSF(nes)
+		return
+	}
+
+	if *Frames > 0 {
+		runHeadless(nes, *Frames)
+		return
+	}
+
 	runtime.LockOSThread()
 
 	window := initGlfw()
@@ -359,26 +519,7 @@ func main() {
 	vao := makeVao(triangle)
 	gl.BindVertexArray(vao)
 
-	nes := internals.NewNES()
-	nes.LoadFile(*ROMFile)
-
-	patterns := nes.Cartridge.CHR_ROM
-
-	line := -1
-	for k := 0; k < int(nes.Cartridge.Header.CHR_ROM_size)/16; k++ {
-		pattern := getPattern(patterns, uint(k))
-
-		if k%32 == 0 {
-			line++
-		}
-		for i := 0; i < 8; i++ {
-			for j := 0; j < 8; j++ {
-				color := pattern[i*8+j]
-
-				image_data[(i+line*8)*256+j+8*(k%32)] = color_palette[color]
-			}
-		}
-	}
+	drawPatterns(nes.Cartridge.CHR_ROM, color_palette)
 
 	gl.UseProgram(program)
 
@@ -389,13 +530,32 @@ func main() {
 
 	if *PPUViewer {
 		for !window.ShouldClose() {
+			// CHR-RAM is filled by the game, so it has to run to show the patterns
+			if nes.Cartridge.CHR_RAM {
+				runFrame(nes)
+				drawPatterns(nes.Cartridge.CHR_ROM, color_palette)
+			}
 			draw(vao, window, program, image_data)
 			glfw.PollEvents()
 			time.Sleep(time.Millisecond * 50)
 		}
 	}
 
-	if !*PPUViewer {
+	if !*PPUViewer && *Pacing != "free" {
+		// The emulation is slowed down either by SwapBuffers waiting for the display, or by the
+		// full audio ring buffer, drained by the timer
+		if *Pacing == "vsync" {
+			glfw.SwapInterval(1)
+		} else {
+			glfw.SwapInterval(0)
+		}
+		for !window.ShouldClose() {
+			runFrame(nes)
+			presentFrame(nes, vao, window, program)
+		}
+	}
+
+	if !*PPUViewer && *Pacing == "free" {
 		// Main loop
 		start := time.Now()
 		ts := start
@@ -408,16 +568,8 @@ func main() {
 				for cycles > 0 {
 					cycles--
 					nes.Step()
-					if nes.PPU.Line == 241 && (nes.PPU.CycleCount >= 1 && nes.PPU.CycleCount <= 3) {
-						for i := 0; i < 256*240; i++ {
-							image_data[i] = nes.PPU.ImageData[i]
-						}
-						draw(vao, window, program, image_data)
-						glfw.PollEvents()
-						if window.GetKey(USER_INPUT.Reset) == 1 { // A
-							nes.CPU.Reset()
-						}
-						nes.Controllers[0].SetInput(getInput(window))
+					if frameReady(nes) {
+						presentFrame(nes, vao, window, program)
 					}
 				}
 			}
@@ -425,6 +577,238 @@ func main() {
 	}
 }
 
+// The frame is complete at the start of the vertical blank
+func frameReady(nes *internals.NES) bool {
+	return nes.PPU.Line == 241 && (nes.PPU.CycleCount >= 1 && nes.PPU.CycleCount <= 3)
+}
+
+func runFrame(nes *internals.NES) {
+	nes.Step()
+	for !frameReady(nes) {
+		nes.Step()
+	}
+}
+
+func presentFrame(nes *internals.NES, vao uint32, window *glfw.Window, program uint32) {
+	for i := 0; i < 256*240; i++ {
+		image_data[i] = nes.PPU.ImageData[i]
+	}
+	draw(vao, window, program, image_data)
+	glfw.PollEvents()
+	if nes.PPU.FrameCount%SAVE_INTERVAL == 0 {
+		saveBattery(nes)
+	}
+	if nes.CPU.Halted != nil && !haltReported {
+		log.Println(nes.CPU.Halted)
+	}
+	haltReported = nes.CPU.Halted != nil
+	if window.GetKey(USER_INPUT.Reset) == 1 { // A
+		nes.CPU.Reset()
+	}
+	nes.Controllers[0].SetInput(getInput(window))
+
+	down := window.GetKey(USER_INPUT.Disk) == glfw.Press
+	if down && !diskKeyDown && nes.FDS != nil {
+		nes.FDS.SwitchSide()
+		log.Println("Switching the disk side")
+	}
+	diskKeyDown = down
+
+	for channel, key := range MUTE_KEYS {
+		if key == glfw.KeyUnknown {
+			continue
+		}
+		down := window.GetKey(key) == glfw.Press
+		if down && !muteKeysDown[channel] {
+			nes.APU.Channels[channel].Mute = !nes.APU.Channels[channel].Mute
+			log.Println("Muted "+internals.CHANNEL_NAMES[channel]+":", nes.APU.Channels[channel].Mute)
+		}
+		muteKeysDown[channel] = down
+	}
+}
+
+var REGION_NAMES = []string{internals.TIMING_NTSC: "NTSC", internals.TIMING_PAL: "PAL", internals.TIMING_MULTIPLE: "Multiple", internals.TIMING_DENDY: "Dendy"}
+
+// Prints the checksums of the ROM and its entry in the game database
+func printInfo(filename string) {
+	nes := internals.NewNES()
+	nes.Patches = PatchFiles
+	nes.ArchiveEntry = *Entry
+	err := nes.LoadFile(filename)
+	var unsupported internals.ErrUnsupportedMapper
+	if err != nil && !errors.As(err, &unsupported) {
+		log.Fatal("Could not load the file: ", err)
+	}
+	if nes.NSF != nil || nes.FDS != nil {
+		log.Fatal("Not a cartridge ROM file: ", filename)
+	}
+
+	cartridge := nes.Cartridge
+	fmt.Printf("CRC32:     %08X\n", cartridge.CRC32)
+	fmt.Printf("SHA-1:     %X\n", cartridge.SHA1)
+	if cartridge.Game == nil {
+		fmt.Println("Not in the game database")
+	} else {
+		fmt.Println("Title:    ", cartridge.Game.Title)
+		if cartridge.Game.Board != "" {
+			fmt.Println("Board:    ", cartridge.Game.Board)
+		}
+		fmt.Println("Region:   ", REGION_NAMES[cartridge.Game.Region])
+	}
+	fmt.Println("Mapper:   ", cartridge.Header.Mapper, "submapper", cartridge.Header.Submapper)
+	fmt.Println("Battery:  ", cartridge.Header.PersistentRAM)
+	if err != nil {
+		fmt.Println("The mapper is not supported")
+	}
+}
+
+func saveBattery(nes *internals.NES) {
+	if err := nes.SaveBattery(); err != nil {
+		log.Println("Could not write the save file:", err)
+	}
+}
+
+func closeStems(nes *internals.NES, stems [internals.CHANNEL_COUNT]*internals.WAVSink) {
+	for channel, stem := range stems {
+		if err := nes.Stems[channel].Flush(); err != nil {
+			log.Println("Could not write the audio:", err)
+		}
+		if err := stem.Close(); err != nil {
+			log.Println("Could not write the WAV file:", err)
+		}
+	}
+}
+
+// Used for testing without a display, e.g. to record the audio to a WAV file
+func runHeadless(nes *internals.NES, frames int) {
+	for ; frames > 0; frames-- {
+		runFrame(nes)
+		if nes.CPU.Halted != nil {
+			log.Println(nes.CPU.Halted)
+			return
+		}
+	}
+}
+
+// Plays the tracks of an NSF file through the audio sink. Each track lasts for its known length,
+// the default length, or the number of frames given with -frames
+func playNSF(nes *internals.NES) {
+	nsf := nes.NSF
+	if nsf == nil {
+		log.Fatal("Not an NSF file: ", *ROMFile)
+	}
+
+	fmt.Println("Title:     ", nsf.Title)
+	fmt.Println("Artist:    ", nsf.Artist)
+	fmt.Println("Copyright: ", nsf.Copyright)
+	if nsf.Ripper != "" {
+		fmt.Println("Ripper:    ", nsf.Ripper)
+	}
+	fmt.Println("Tracks:    ", nsf.Tracks)
+	if nsf.ExpansionChips != 0 {
+		fmt.Printf("The file uses expansion audio (%02X), which is not emulated\n", nsf.ExpansionChips)
+	}
+
+	var tracks []int
+	if *Track > 0 {
+		if *Track > nsf.Tracks {
+			log.Fatal("Invalid track. The file has ", nsf.Tracks, " tracks")
+		}
+		tracks = append(tracks, *Track-1)
+	} else {
+		for i := 0; i < nsf.Tracks; i++ {
+			tracks = append(tracks, (nsf.StartingTrack+i)%nsf.Tracks)
+		}
+	}
+
+	for _, track := range tracks {
+		duration := nsf.TrackDuration(track)
+		if *Frames > 0 {
+			duration = *Frames * 1000 / 60
+		}
+		fmt.Printf("Track %d/%d %s (%d:%02d)\n", track+1, nsf.Tracks, nsf.TrackLabels[track], duration/60000, duration/1000%60)
+
+		nes.PlayTrack(track)
+		for cycles := duration * FREQUENCY / 1000; cycles > 0; cycles-- {
+			nes.Step()
+		}
+	}
+}
+
+// Consumes the samples of the ring buffer in real time on a timer, so the emulation is paced the
+// same way. There is no sound card behind it, nothing is played: the samples only go to output,
+// if there is one
+type timerSink struct {
+	ring   *internals.RingBuffer
+	output internals.AudioSink
+	stop   chan bool
+	done   chan bool
+}
+
+func startTimerSink(ring *internals.RingBuffer, output internals.AudioSink) *timerSink {
+	device := &timerSink{ring: ring, output: output, stop: make(chan bool), done: make(chan bool)}
+	go device.run()
+	return device
+}
+
+func (device *timerSink) run() {
+	defer close(device.done)
+
+	rate := device.ring.SampleRate()
+	buffer := make([]float32, rate/100)
+	var played int64
+	start := time.Now()
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-device.stop:
+			return
+		case now := <-ticker.C:
+			target := int64(now.Sub(start).Seconds() * float64(rate))
+			for played < target {
+				count := len(buffer)
+				if target-played < int64(count) {
+					count = int(target - played)
+				}
+				read := device.ring.ReadSamples(buffer[:count])
+				for i := read; i < count; i++ {
+					buffer[i] = 0 // Underrun
+				}
+				if device.output != nil {
+					if err := device.output.WriteSamples(buffer[:count]); err != nil {
+						log.Println("Could not write the audio:", err)
+						device.output = nil
+					}
+				}
+				played += int64(count)
+			}
+		}
+	}
+}
+
+func (device *timerSink) Stop() {
+	device.ring.Close()
+	close(device.stop)
+	<-device.done
+}
+
+func closeAudio(nes *internals.NES, device *timerSink, wav *internals.WAVSink) {
+	if device != nil {
+		device.Stop()
+	} else if nes.Audio != nil {
+		if err := nes.Audio.Flush(); err != nil {
+			log.Println("Could not write the audio:", err)
+		}
+	}
+	if wav != nil {
+		if err := wav.Close(); err != nil {
+			log.Println("Could not write the WAV file:", err)
+		}
+	}
+}
+
 func getInput(window *glfw.Window) [8]bool {
 	var input [8]bool
 	if window.GetKey(USER_INPUT.A) == 1 { // A
@@ -454,6 +838,25 @@ func getInput(window *glfw.Window) [8]bool {
 	return input
 }
 
+// Draws the pattern tables, 32 tiles per line
+func drawPatterns(patterns []byte, color_palette []uint8) {
+	line := -1
+	for k := 0; k < len(patterns)/16 && k < 32*30; k++ {
+		pattern := getPattern(patterns, uint(k))
+
+		if k%32 == 0 {
+			line++
+		}
+		for i := 0; i < 8; i++ {
+			for j := 0; j < 8; j++ {
+				color := pattern[i*8+j]
+
+				image_data[(i+line*8)*256+j+8*(k%32)] = color_palette[color]
+			}
+		}
+	}
+}
+
 func getPattern(patterns []byte, index uint) [64]uint8 {
 	pattern := patterns[index*16 : index*16+16]
 	var pixels [64]byte